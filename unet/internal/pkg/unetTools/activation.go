@@ -0,0 +1,185 @@
+package unetTools
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Activation is an element-wise activation function.
+// Derivative is evaluated at the pre-activation value x, like Forward.
+type Activation interface {
+	Forward(x float64) float64
+	Derivative(x float64) float64
+}
+
+// ReLU is max(0, x)
+type ReLU struct{}
+
+func (ReLU) Forward(x float64) float64 { return math.Max(0, x) }
+
+func (ReLU) Derivative(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+// LeakyReLU is x for positive x and Slope * x otherwise
+type LeakyReLU struct {
+	Slope float64
+}
+
+func (a LeakyReLU) Forward(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return a.Slope * x
+}
+
+func (a LeakyReLU) Derivative(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return a.Slope
+}
+
+// ELU is x for positive x and Alpha * (e^x - 1) otherwise
+type ELU struct {
+	Alpha float64
+}
+
+func (a ELU) Forward(x float64) float64 {
+	if x > 0 {
+		return x
+	}
+	return a.Alpha * math.Expm1(x)
+}
+
+func (a ELU) Derivative(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return a.Alpha * math.Exp(x)
+}
+
+// GELU is x * Φ(x), with Φ the standard normal cumulative distribution
+// (the exact form, not the tanh approximation)
+type GELU struct{}
+
+func (GELU) Forward(x float64) float64 {
+	return x * 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+func (GELU) Derivative(x float64) float64 {
+	cdf := 0.5 * (1 + math.Erf(x/math.Sqrt2))
+	pdf := math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+	return cdf + x*pdf
+}
+
+// Tanh is the hyperbolic tangent
+type Tanh struct{}
+
+func (Tanh) Forward(x float64) float64 { return math.Tanh(x) }
+
+func (Tanh) Derivative(x float64) float64 {
+	t := math.Tanh(x)
+	return 1 - t*t
+}
+
+// Softplus is log(1 + e^x), a smooth ReLU
+type Softplus struct{}
+
+func (Softplus) Forward(x float64) float64 {
+	// stable for large |x|
+	return math.Max(x, 0) + math.Log1p(math.Exp(-math.Abs(x)))
+}
+
+func (Softplus) Derivative(x float64) float64 { return sigmoid(x) }
+
+// Identity leaves its input unchanged
+type Identity struct{}
+
+func (Identity) Forward(x float64) float64 { return x }
+
+func (Identity) Derivative(float64) float64 { return 1 }
+
+// Sigmoid is 1 / (1 + e^-x)
+type Sigmoid struct{}
+
+func (Sigmoid) Forward(x float64) float64 { return sigmoid(x) }
+
+func (Sigmoid) Derivative(x float64) float64 {
+	s := sigmoid(x)
+	return s * (1 - s)
+}
+
+// sigmoid computes 1 / (1 + e^-x) without overflowing for large negative x
+func sigmoid(x float64) float64 {
+	if x >= 0 {
+		return 1 / (1 + math.Exp(-x))
+	}
+	e := math.Exp(x)
+	return e / (1 + e)
+}
+
+var (
+	activationsMu sync.RWMutex
+	// activations maps the names used in ConvParams.Activation to their functions
+	activations = map[string]Activation{
+		"":          Identity{},
+		"identity":  Identity{},
+		"relu":      ReLU{},
+		"leakyrelu": LeakyReLU{Slope: 0.01},
+		"elu":       ELU{Alpha: 1},
+		"gelu":      GELU{},
+		"tanh":      Tanh{},
+		"softplus":  Softplus{},
+		"sigmoid":   Sigmoid{},
+	}
+)
+
+// RegisterActivation makes activation available under name, replacing any
+// activation already registered under that name
+func RegisterActivation(name string, activation Activation) {
+	activationsMu.Lock()
+	defer activationsMu.Unlock()
+	activations[name] = activation
+}
+
+// GetActivation returns the activation registered under name.
+// "" is the identity.
+func GetActivation(name string) (Activation, error) {
+	activationsMu.RLock()
+	defer activationsMu.RUnlock()
+	activation, ok := activations[name]
+	if !ok {
+		names := make([]string, 0, len(activations))
+		for n := range activations {
+			if n != "" {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown activation %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return activation, nil
+}
+
+// mustActivation returns the activation registered under name and panics if there is none
+func mustActivation(name string) Activation {
+	activation, err := GetActivation(name)
+	if err != nil {
+		panic(err)
+	}
+	return activation
+}
+
+// applyActivation replaces every value with activation.Forward(value), in place
+func applyActivation(values []float64, activation Activation) {
+	for i, v := range values {
+		values[i] = activation.Forward(v)
+	}
+}
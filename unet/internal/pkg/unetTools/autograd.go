@@ -0,0 +1,262 @@
+package unetTools
+
+import (
+	"fmt"
+)
+
+// tapeNode records a single operation of a forward pass
+type tapeNode struct {
+	op       string
+	inputs   []*Tensor
+	output   *Tensor
+	backward func(gradOutput *Tensor) []*Tensor // returns one gradient per input (nil if not needed)
+}
+
+// Tape records the operations of a forward pass so that their gradients can
+// be computed exactly by replaying them in reverse order (reverse-mode autodiff).
+//
+// Gradients with respect to intermediate tensors are tracked by the Tape.
+// Gradients with respect to layer parameters are accumulated by the layers
+// themselves when their Backward is replayed, and are exposed through Grads.
+//
+// A nil *Tape is valid: every operation is still computed, just not recorded.
+type Tape struct {
+	nodes []*tapeNode
+	grads map[*Tensor]*Tensor
+}
+
+// NewTape initializes a new, empty Tape
+func NewTape() *Tape {
+	return &Tape{
+		grads: make(map[*Tensor]*Tensor),
+	}
+}
+
+// Reset forgets every recorded operation and gradient
+func (tape *Tape) Reset() {
+	if tape == nil {
+		return
+	}
+	tape.nodes = nil
+	tape.grads = make(map[*Tensor]*Tensor)
+}
+
+// Len returns the number of recorded operations
+func (tape *Tape) Len() int {
+	if tape == nil {
+		return 0
+	}
+	return len(tape.nodes)
+}
+
+// Ops returns the names of the recorded operations in the order they were recorded
+func (tape *Tape) Ops() []string {
+	if tape == nil {
+		return nil
+	}
+	ops := make([]string, len(tape.nodes))
+	for i, node := range tape.nodes {
+		ops[i] = node.op
+	}
+	return ops
+}
+
+// Record appends an operation to the Tape. backward receives the gradient of
+// output and must return the gradients of inputs, in order.
+func (tape *Tape) Record(op string, inputs []*Tensor, output *Tensor, backward func(gradOutput *Tensor) []*Tensor) {
+	if tape == nil {
+		return
+	}
+	tape.nodes = append(tape.nodes, &tapeNode{
+		op:       op,
+		inputs:   inputs,
+		output:   output,
+		backward: backward,
+	})
+}
+
+// Layer runs layer.Forward on input and records it.
+// The layer's own Backward is replayed during Tape.Backward.
+func (tape *Tape) Layer(layer Layer, input *Tensor) *Tensor {
+	output := layer.Forward(input)
+	tape.Record(layerOp(layer), []*Tensor{input}, output, func(gradOutput *Tensor) []*Tensor {
+		return []*Tensor{layer.Backward(gradOutput)}
+	})
+	return output
+}
+
+// Concat concatenates a and b along the channel axis and records it
+func (tape *Tape) Concat(a, b *Tensor) *Tensor {
+	output := ConcatChannels(a, b)
+	_, split, _, _ := a.Dims()
+	tape.Record("concat", []*Tensor{a, b}, output, func(gradOutput *Tensor) []*Tensor {
+		gradA, gradB := SplitChannels(gradOutput, split)
+		return []*Tensor{gradA, gradB}
+	})
+	return output
+}
+
+// Resize bilinearly resizes every channel of input to h x w and records it
+func (tape *Tape) Resize(input *Tensor, h, w int) *Tensor {
+	output := ResizeTensor(input, h, w)
+	_, _, inputRows, inputCols := input.Dims()
+	tape.Record("resize", []*Tensor{input}, output, func(gradOutput *Tensor) []*Tensor {
+		return []*Tensor{ResizeTensorBackward(gradOutput, inputRows, inputCols)}
+	})
+	return output
+}
+
+// Activation applies the named activation function to a copy of input and records it.
+// It panics if no activation is registered under name.
+func (tape *Tape) Activation(input *Tensor, name string) *Tensor {
+	activation := mustActivation(name)
+	output := input.Clone()
+	applyActivation(output.Data, activation)
+	tape.Record("activation", []*Tensor{input}, output, func(gradOutput *Tensor) []*Tensor {
+		gradInput := gradOutput.Clone()
+		for i, v := range input.Data {
+			gradInput.Data[i] *= activation.Derivative(v)
+		}
+		return []*Tensor{gradInput}
+	})
+	return output
+}
+
+// Loss computes value(pred, target) and records it. The returned 1x1x1x1
+// Tensor holds the loss and is the root to pass to Tape.Backward.
+// grad must return the gradient of the loss with respect to pred.
+func (tape *Tape) Loss(
+	pred *Tensor,
+	target *Tensor,
+	value func(pred, target *Tensor) float64,
+	grad func(pred, target *Tensor) *Tensor,
+) *Tensor {
+	output := NewTensorFromData(1, 1, 1, 1, []float64{value(pred, target)})
+	tape.Record("loss", []*Tensor{pred}, output, func(gradOutput *Tensor) []*Tensor {
+		gradPred := grad(pred, target)
+		gradPred.Scale(gradOutput.Data[0])
+		return []*Tensor{gradPred}
+	})
+	return output
+}
+
+// BatchLoss computes value(pred_n, target_n) for every sample n of the batch,
+// reduces the per-sample losses with reduction (one of the LossReduction*
+// modes) and records it. grad must return the gradient of the loss of one
+// sample with respect to its prediction; both functions receive 1 x C x H x W
+// views of the samples.
+//
+// With LossReductionMean and LossReductionSum the returned Tensor is 1x1x1x1.
+// With LossReductionNone it is N x 1 x 1 x 1 and holds one loss per sample;
+// Tape.Backward then seeds every sample with 1, as if their losses were summed.
+func (tape *Tape) BatchLoss(
+	pred *Tensor,
+	target *Tensor,
+	reduction string,
+	value func(pred, target *Tensor) float64,
+	grad func(pred, target *Tensor) *Tensor,
+) *Tensor {
+	checkLossReduction(reduction)
+	mustSameShape("BatchLoss", pred, target)
+	n, _, _, _ := pred.Dims()
+	losses := NewTensor(n, 1, 1, 1)
+	for i := 0; i < n; i++ {
+		losses.Data[i] = value(pred.Sample(i), target.Sample(i))
+	}
+
+	output := losses
+	scale := 1.0
+	switch reduction {
+	case LossReductionMean:
+		scale = 1 / float64(n)
+		output = NewTensorFromData(1, 1, 1, 1, []float64{losses.Sum() * scale})
+	case LossReductionSum:
+		output = NewTensorFromData(1, 1, 1, 1, []float64{losses.Sum()})
+	}
+	tape.Record("loss", []*Tensor{pred}, output, func(gradOutput *Tensor) []*Tensor {
+		gradPred := NewTensorLike(pred)
+		for i := 0; i < n; i++ {
+			seed := gradOutput.Data[0]
+			if reduction == LossReductionNone {
+				seed = gradOutput.Data[i]
+			}
+			gradSample := grad(pred.Sample(i), target.Sample(i))
+			gradSample.Scale(seed * scale)
+			gradPred.Sample(i).Add(gradSample)
+		}
+		return []*Tensor{gradPred}
+	})
+	return output
+}
+
+// Backward replays the recorded operations in reverse order, starting from
+// root with a gradient of ones, and accumulates the gradient of every
+// recorded tensor. Tensors used by several operations receive the sum of
+// their gradients.
+func (tape *Tape) Backward(root *Tensor) {
+	if tape == nil {
+		panic("Tape.Backward called on a nil Tape")
+	}
+	seed := NewTensorLike(root)
+	seed.Apply(func(float64) float64 { return 1 })
+	tape.grads[root] = seed
+
+	for i := len(tape.nodes) - 1; i >= 0; i-- {
+		node := tape.nodes[i]
+		gradOutput, ok := tape.grads[node.output]
+		if !ok {
+			// the output does not contribute to root
+			continue
+		}
+		gradInputs := node.backward(gradOutput)
+		if len(gradInputs) != len(node.inputs) {
+			panic(fmt.Sprintf("%s: backward returned %d gradients for %d inputs", node.op, len(gradInputs), len(node.inputs)))
+		}
+		for j, input := range node.inputs {
+			if gradInputs[j] == nil {
+				continue
+			}
+			tape.accumulate(input, gradInputs[j])
+		}
+	}
+}
+
+// accumulate adds grad to the gradient tracked for t
+func (tape *Tape) accumulate(t *Tensor, grad *Tensor) {
+	existing, ok := tape.grads[t]
+	if !ok {
+		tape.grads[t] = grad
+		return
+	}
+	// never write into a gradient tensor that may still be referenced elsewhere
+	sum := existing.Clone()
+	sum.Add(grad)
+	tape.grads[t] = sum
+}
+
+// Grad returns the gradient of t computed by the last Backward, or nil
+// if t does not contribute to the root
+func (tape *Tape) Grad(t *Tensor) *Tensor {
+	if tape == nil {
+		return nil
+	}
+	return tape.grads[t]
+}
+
+// layerOp returns the name under which a layer is recorded on the Tape
+func layerOp(layer Layer) string {
+	switch layer.(type) {
+	case *ConvLayer:
+		return "conv"
+	case *ConvTransLayer:
+		return "conv_trans"
+	case *MaxPoolLayer:
+		return "pool"
+	case *UpsampleLayer:
+		return "upsample"
+	case *SoftmaxLayer:
+		return "softmax"
+	default:
+		return "layer"
+	}
+}
@@ -0,0 +1,38 @@
+package unetTools
+
+import (
+	"math"
+)
+
+// GradNorm returns the global L2 norm of grads, taken over every element of every Tensor
+func GradNorm(grads []*Tensor) float64 {
+	sum := 0.0
+	for _, grad := range grads {
+		for _, g := range grad.Data {
+			sum += g * g
+		}
+	}
+	return math.Sqrt(sum)
+}
+
+// ClipGradValue clamps every element of grads to [-limit, limit], in place
+func ClipGradValue(grads []*Tensor, limit float64) {
+	for _, grad := range grads {
+		grad.Apply(func(g float64) float64 {
+			return math.Max(-limit, math.Min(limit, g))
+		})
+	}
+}
+
+// ClipGradNorm scales grads in place so that their global L2 norm is at most
+// maxNorm, keeping their direction. It returns the norm before clipping.
+func ClipGradNorm(grads []*Tensor, maxNorm float64) float64 {
+	norm := GradNorm(grads)
+	if norm > maxNorm {
+		scale := maxNorm / norm
+		for _, grad := range grads {
+			grad.Scale(scale)
+		}
+	}
+	return norm
+}
@@ -0,0 +1,107 @@
+package unetTools
+
+import (
+	"fmt"
+)
+
+// LossTerm is one weighted component of a CompositeLoss
+type LossTerm struct {
+	Name     string    // Name of the component in the training logs
+	Loss     Loss      // Loss of the component
+	Weight   float64   // Weight of the component, the base value of Schedule
+	Schedule Scheduler // Weight of every step, nil keeps Weight
+}
+
+// LossComponent reports the contribution of one LossTerm
+type LossComponent struct {
+	Name   string
+	Weight float64 // Weight of the current step
+	Value  float64 // Mean unweighted value over the samples since the last step
+}
+
+// CompositeLoss is the weighted sum of several losses, such as
+// 0.5 * BinaryCrossEntropy + 0.5 * Dice, and its gradient is the weighted sum
+// of their gradients. The weight of every term can follow a Scheduler, which
+// receives the Weight of the term as its base value and the number of
+// optimizer steps taken, as reported by Unet through ObserveStep.
+type CompositeLoss struct {
+	Terms []LossTerm
+
+	step  int       // optimizer steps taken
+	sums  []float64 // sum of the values of every term since the last ObserveStep
+	count int       // number of samples in sums
+}
+
+// NewCompositeLoss initializes a new instance of CompositeLoss
+func NewCompositeLoss(terms ...LossTerm) *CompositeLoss {
+	if len(terms) == 0 {
+		panic("CompositeLoss needs at least one term")
+	}
+	for i, term := range terms {
+		if term.Loss == nil {
+			panic(fmt.Sprintf("CompositeLoss term %d (%q) has no loss", i, term.Name))
+		}
+	}
+	return &CompositeLoss{
+		Terms: terms,
+		sums:  make([]float64, len(terms)),
+	}
+}
+
+// ObserveStep sets the step the weights are scheduled for and starts
+// collecting the values of a new step
+func (cl *CompositeLoss) ObserveStep(step int) {
+	cl.step = step
+	cl.count = 0
+	for i := range cl.sums {
+		cl.sums[i] = 0
+	}
+}
+
+// Weight returns the weight of term i at the current step
+func (cl *CompositeLoss) Weight(i int) float64 {
+	term := cl.Terms[i]
+	if term.Schedule == nil {
+		return term.Weight
+	}
+	return term.Schedule.LearningRate(cl.step, term.Weight)
+}
+
+// Value returns the weighted sum of the values of every term, and records
+// them for Components
+func (cl *CompositeLoss) Value(pred, target *Tensor) float64 {
+	total := 0.0
+	for i, term := range cl.Terms {
+		value := term.Loss.Value(pred, target)
+		cl.sums[i] += value
+		total += cl.Weight(i) * value
+	}
+	cl.count++
+	return total
+}
+
+// Grad returns the weighted sum of the gradients of every term
+func (cl *CompositeLoss) Grad(pred, target *Tensor) *Tensor {
+	grad := NewTensorLike(pred)
+	for i, term := range cl.Terms {
+		if weight := cl.Weight(i); weight != 0 {
+			termGrad := term.Loss.Grad(pred, target)
+			termGrad.Scale(weight)
+			grad.Add(termGrad)
+		}
+	}
+	return grad
+}
+
+// Components returns the weight and mean value of every term over the
+// samples seen since the last ObserveStep
+func (cl *CompositeLoss) Components() []LossComponent {
+	components := make([]LossComponent, len(cl.Terms))
+	for i, term := range cl.Terms {
+		components[i] = LossComponent{Name: term.Name, Weight: cl.Weight(i)}
+		if cl.count > 0 {
+			components[i].Value = cl.sums[i] / float64(cl.count)
+		}
+	}
+	return components
+}
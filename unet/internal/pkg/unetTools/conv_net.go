@@ -2,10 +2,9 @@ package unetTools
 
 import (
 	"fmt"
-	"math"
+	"math/rand"
 
 	"github.com/gonum/matrix/mat64"
-	mat "github.com/gonum/matrix/mat64"
 )
 
 // ConvParams represents the parameters for a convolutional layer
@@ -14,42 +13,77 @@ type ConvParams struct {
 	InputChannels int
 	KernelSize    int
 	NumFilters    int
-	// and now for the AdamW optimizer
-	beta1   float64
-	beta2   float64
-	epsilon float64
+	Padding       string     // one of the Padding* modes, "" means PaddingValid
+	Stride        int        // step between kernel positions, 0 means 1
+	Dilation      int        // spacing between kernel taps, 0 means 1
+	Backend       string     // one of the ConvBackend* values, "" means ConvBackendGemm
+	WeightInit    string     // one of the Init* initializers, "" means InitUniform
+	BiasInit      string     // one of the Init* initializers, "" means InitUniform
+	Rand          *rand.Rand // source of the initial values, nil draws a seed from math/rand
 }
 
 // ConvLayer represents a convolutional layer
 type ConvLayer struct {
-	Weights       []*mat64.Dense
-	Biases        []*mat64.Dense
+	Weights       *Tensor // NumFilters x InputChannels x KernelSize x KernelSize
+	Biases        *Tensor // NumFilters x 1 x 1 x 1
 	KernelSize    int
 	Activation    string
 	InputChannels int
 	NumFilters    int
-	// and now for the AdamW optimizer
-	beta1    float64
-	beta2    float64
-	epsilon  float64
-	mWeights *mat64.Dense
-	vWeights *mat64.Dense
-	mBiases  *mat64.Dense
-	vBiases  *mat64.Dense
-	t        int
-	_input   []*mat64.Dense
-	_output  []*mat64.Dense
+	Padding       string
+	Stride        int
+	Dilation      int
+	Backend       string
+	activation    Activation // the function registered under Activation
+	_input        *Tensor
+	_padded       *Tensor // input after padding
+	_preact       *Tensor // output before the activation function
+	_output       *Tensor
+
+	gradWeights *Tensor
+	gradBiases  *Tensor
 }
 
-// NewConvLayer initializes a new instance of ConvLayer
+// NewConvLayer initializes a new instance of ConvLayer without padding,
+// with a stride and dilation of 1
 func NewConvLayer(InputChannels, KernelSize, NumFilters int, Activation string) *ConvLayer {
-	// the number of filters determines the number of matrices used for the weights
-	Weights := make([]*mat64.Dense, NumFilters)
-	Biases := make([]*mat64.Dense, NumFilters)
-	for i := 0; i < NumFilters; i++ {
-		Weights[i] = mat64.NewDense(KernelSize, KernelSize, randomMatrixValues(KernelSize*KernelSize))
-		Biases[i] = mat64.NewDense(1, 1, randomMatrixValues(1))
+	return NewConvLayerFromParams(ConvParams{
+		Activation:    Activation,
+		InputChannels: InputChannels,
+		KernelSize:    KernelSize,
+		NumFilters:    NumFilters,
+	})
+}
+
+// NewConvLayerFromParams initializes a new instance of ConvLayer from params
+func NewConvLayerFromParams(params ConvParams) *ConvLayer {
+	checkPadding(params.Padding)
+	Stride := params.Stride
+	if Stride == 0 {
+		Stride = 1
+	}
+	Dilation := params.Dilation
+	if Dilation == 0 {
+		Dilation = 1
+	}
+	if Stride < 0 || Dilation < 0 {
+		panic(fmt.Sprintf("ConvLayer stride and dilation must be positive, got %d and %d", params.Stride, params.Dilation))
 	}
+	Backend := params.Backend
+	if Backend == "" {
+		Backend = ConvBackendGemm
+	}
+	checkConvBackend(Backend)
+	InputChannels := params.InputChannels
+	KernelSize := params.KernelSize
+	NumFilters := params.NumFilters
+	Activation := params.Activation
+	// every filter has one kernel per input channel
+	rng := randOrGlobal(params.Rand)
+	Weights := NewTensor(NumFilters, InputChannels, KernelSize, KernelSize)
+	initTensor(Weights, params.WeightInit, InputChannels*KernelSize*KernelSize, NumFilters*KernelSize*KernelSize, rng)
+	Biases := NewTensor(NumFilters, 1, 1, 1)
+	initTensor(Biases, params.BiasInit, InputChannels*KernelSize*KernelSize, NumFilters*KernelSize*KernelSize, rng)
 	return &ConvLayer{
 		Weights:       Weights,
 		Biases:        Biases,
@@ -57,167 +91,203 @@ func NewConvLayer(InputChannels, KernelSize, NumFilters int, Activation string)
 		Activation:    Activation,
 		InputChannels: InputChannels,
 		NumFilters:    NumFilters,
-		// and now for the AdamW optimizer
-		beta1:    0.9,
-		beta2:    0.999,
-		epsilon:  1e-8,
-		mWeights: mat64.NewDense(KernelSize, KernelSize, nil),
-		vWeights: mat64.NewDense(KernelSize, KernelSize, nil),
-		mBiases:  mat64.NewDense(1, 1, nil),
-		vBiases:  mat64.NewDense(1, 1, nil),
-		t:        0,
+		Padding:       params.Padding,
+		Stride:        Stride,
+		Dilation:      Dilation,
+		Backend:       Backend,
+		activation:    mustActivation(Activation),
+
+		gradWeights: NewTensorLike(Weights),
+		gradBiases:  NewTensorLike(Biases),
 	}
 
 }
 
+// span returns the number of input rows (or columns) covered by one kernel
+// position, taking the dilation into account
+func (cl *ConvLayer) span() int {
+	return cl.Dilation*(cl.KernelSize-1) + 1
+}
+
+// outputSize returns the number of kernel positions along an axis of
+// length size, which must already include the padding
+func (cl *ConvLayer) outputSize(size int) int {
+	if size < cl.span() {
+		panic(fmt.Sprintf("ConvLayer input size %d is smaller than the kernel span %d", size, cl.span()))
+	}
+	return (size-cl.span())/cl.Stride + 1
+}
+
+// Convolve correlates a single channel with a kernel, normalized by the kernel area,
+// and adds the bias. The kernel moves by the layer's Stride and its taps are
+// Dilation elements apart. biases may be nil.
 func (cl *ConvLayer) Convolve(input, weights, biases *mat64.Dense) *mat64.Dense {
 	inputRows, inputCols := input.Dims()
 	weightsRows, weightsCols := weights.Dims()
-	outputRows := inputRows - weightsRows + 1
-	outputCols := inputCols - weightsCols + 1
+	stride, dilation := cl.Stride, cl.Dilation
+	outputRows := (inputRows-dilation*(weightsRows-1)-1)/stride + 1
+	outputCols := (inputCols-dilation*(weightsCols-1)-1)/stride + 1
 	output := mat64.NewDense(outputRows, outputCols, nil)
 
+	b := 0.0
+	if biases != nil {
+		b = biases.At(0, 0)
+	}
 	for i := 0; i < outputRows; i++ {
 		for j := 0; j < outputCols; j++ {
 			sum := 0.0
 			for m := 0; m < weightsRows; m++ {
 				for n := 0; n < weightsCols; n++ {
-					sum += input.At(i+m, j+n) * weights.At(m, n)
+					sum += input.At(i*stride+m*dilation, j*stride+n*dilation) * weights.At(m, n)
 				}
 			}
 			sum /= float64(weightsRows * weightsCols)
-			output.Set(i, j, sum+biases.At(0, 0))
+			output.Set(i, j, sum+b)
 		}
 	}
 
 	return output
 }
 
-// Forward performs a forward pass through the ConvLayer
-func (cl *ConvLayer) Forward(input []*mat64.Dense) []*mat64.Dense {
+// Forward performs a forward pass through the ConvLayer.
+// Every filter correlates each input channel with its own kernel
+// and sums the results over the channels before adding its bias.
+func (cl *ConvLayer) Forward(input *Tensor) *Tensor {
+	n, c, h, w := input.Dims()
+	if c != cl.InputChannels {
+		panic(fmt.Sprintf("ConvLayer expects %d input channels, got %d", cl.InputChannels, c))
+	}
 	cl._input = input
-	layer_out := make([]*mat64.Dense, cl.NumFilters)
-
-	for i := 0; i < cl.NumFilters; i++ {
-		for j := 0; j < len(input); j++ {
-			if j == 0 {
-				layer_out[i] = cl.Convolve(input[j], cl.Weights[i], cl.Biases[i])
-			} else {
-				layer_out[i].Add(layer_out[i], cl.Convolve(input[j], cl.Weights[i], cl.Biases[i]))
-			}
-		}
-		layer_out[i].Scale(1.0/float64(len(input)), layer_out[i])
-		applyActivation(layer_out[i], cl.Activation)
+	top, bottom := padAmounts(cl.Padding, h, cl.span(), cl.Stride)
+	left, right := padAmounts(cl.Padding, w, cl.span(), cl.Stride)
+	cl._padded = padTensor(input, cl.Padding, top, bottom, left, right)
+	layer_out := NewTensor(n, cl.NumFilters, cl.outputSize(h+top+bottom), cl.outputSize(w+left+right))
+
+	if cl.Backend == ConvBackendNaive {
+		cl.forwardNaive(layer_out)
+	} else {
+		cl.forwardGemm(layer_out)
 	}
 
+	cl._preact = layer_out.Clone()
+	parallelFor(n*cl.NumFilters, func(u int) {
+		applyActivation(layer_out.Plane(u/cl.NumFilters, u%cl.NumFilters), cl.activation)
+	})
+
 	cl._output = layer_out
 	return layer_out
 }
 
+// forwardNaive writes the pre-activation output of the layer into out by
+// correlating every padded input channel with its kernel through Convolve,
+// one output plane per unit of parallel work
+func (cl *ConvLayer) forwardNaive(out *Tensor) {
+	n, c, _, _ := cl._padded.Dims()
+	parallelFor(n*cl.NumFilters, func(u int) {
+		s, i := u/cl.NumFilters, u%cl.NumFilters
+		channel := out.Channel(s, i)
+		for j := 0; j < c; j++ {
+			channel.Add(channel, cl.Convolve(cl._padded.Channel(s, j), cl.Weights.Channel(i, j), nil))
+		}
+		bias := cl.Biases.At(i, 0, 0, 0)
+		plane := out.Plane(s, i)
+		for k := range plane {
+			plane[k] += bias
+		}
+	})
+}
+
 // Backward computes the backward pass of the convolutional layer.
-// It takes the gradient of the output matrix as input,
-// and returns the gradient of the input matrix, the gradient of the Weights matrix,
-// and the gradient of the biases matrix.
-func (cl *ConvLayer) Backward(outputGrad *mat.Dense, learningRate float64) {
-	// Iterate over each location in the output gradient
-	fmt.Println("In ConvLayer.Backward:")
-	fmt.Printf("OutputGrad size: %d x %d\n", outputGrad.RawMatrix().Rows, outputGrad.RawMatrix().Cols)
-	fmt.Printf("Input size:      %d x %d\n", cl._input[0].RawMatrix().Rows, cl._input[0].RawMatrix().Cols)
-	for i := 0; i < cl.NumFilters; i++ {
-		// Initialize gradients of weights and biases
-
-		gradWeights := make([]*mat64.Dense, len(cl._input))
-		gradBiases := mat64.NewDense(1, 1, nil)
-		for j := 0; j < len(cl._input); j++ {
-			gradWeights[j] = mat64.NewDense(cl.KernelSize, cl.KernelSize, nil)
+// It takes the gradient of the output as input, accumulates the gradients
+// of the Weights and biases, and returns the gradient of the input.
+//
+// Forward computes, for every filter f,
+//
+//	preact_f[i, j] = 1/(K*K) * sum_c sum_{x,y} input_c[i*S + x*D, j*S + y*D] * W_fc[x, y] + b_f
+//	output_f = activation(preact_f)
+//
+// with stride S and dilation D, so the gradient is first taken through the
+// activation, then through the scaled correlation: every output position
+// scatters its gradient back onto the input positions its kernel taps read.
+// Finally the gradient of the padded input is folded back onto the input.
+func (cl *ConvLayer) Backward(gradOutput *Tensor) *Tensor {
+	_, _, inputRows, inputCols := cl._input.Dims()
+	mustSameShape("ConvLayer.Backward", gradOutput, cl._output)
+
+	// gradient with respect to the pre-activation values
+	gradPreact := gradOutput.Clone()
+	for i, v := range cl._preact.Data {
+		gradPreact.Data[i] *= cl.activation.Derivative(v)
+	}
+
+	gradPadded := NewTensorLike(cl._padded)
+	if cl.Backend == ConvBackendNaive {
+		cl.backwardNaive(gradPreact, gradPadded)
+	} else {
+		cl.backwardGemm(gradPreact, gradPadded)
+	}
+	top, _ := padAmounts(cl.Padding, inputRows, cl.span(), cl.Stride)
+	left, _ := padAmounts(cl.Padding, inputCols, cl.span(), cl.Stride)
+	return unpadTensorGrad(gradPadded, cl.Padding, top, left, inputRows, inputCols)
+}
+
+// backwardNaive accumulates the parameter gradients and adds the gradient of
+// the padded input to gradPadded, one output position and kernel tap at a time.
+// The bias gradients are split by filter, and everything else by input channel
+// so that no two units write to the same gradient.
+func (cl *ConvLayer) backwardNaive(gradPreact, gradPadded *Tensor) {
+	numSamples, numChannels, _, _ := cl._padded.Dims()
+	_, _, outputRows, outputCols := gradPreact.Dims()
+	scale := 1.0 / float64(cl.KernelSize*cl.KernelSize)
+
+	parallelFor(cl.NumFilters, func(i int) {
+		for s := 0; s < numSamples; s++ {
+			for _, grad := range gradPreact.Plane(s, i) {
+				cl.gradBiases.Data[i] += grad
+			}
 		}
+	})
 
-		// Iterate over each location in the output gradient
-		for outX := 0; outX < outputGrad.RawMatrix().Rows; outX++ {
-			for outY := 0; outY < outputGrad.RawMatrix().Cols; outY++ {
-				// Compute the gradients for each weight in the kefrnel
-				for x := 0; x < cl.KernelSize; x++ {
-					for y := 0; y < cl.KernelSize; y++ {
-						for c := 0; c < len(cl._input); c++ {
-							// Compute the gradient of the loss with respect to this weight
-							gradWeights[c].Set(x, y, gradWeights[c].At(x, y)+
-								outputGrad.At(outX, outY)*cl._input[c].At(outX+x, outY+y))
+	parallelFor(numChannels, func(c int) {
+		for s := 0; s < numSamples; s++ {
+			for i := 0; i < cl.NumFilters; i++ {
+				weights := cl.Weights.Channel(i, c)
+				gradWeights := cl.gradWeights.Channel(i, c)
+
+				// Iterate over each location in the output gradient
+				for outX := 0; outX < outputRows; outX++ {
+					for outY := 0; outY < outputCols; outY++ {
+						grad := gradPreact.At(s, i, outX, outY)
+						if grad == 0 {
+							continue
+						}
+						for x := 0; x < cl.KernelSize; x++ {
+							for y := 0; y < cl.KernelSize; y++ {
+								inX := outX*cl.Stride + x*cl.Dilation
+								inY := outY*cl.Stride + y*cl.Dilation
+								// gradient of the loss with respect to this weight
+								gradWeights.Set(x, y, gradWeights.At(x, y)+
+									grad*cl._padded.At(s, c, inX, inY)*scale)
+								// gradient of the loss with respect to this input
+								idx := gradPadded.Index(s, c, inX, inY)
+								gradPadded.Data[idx] += grad * weights.At(x, y) * scale
+							}
 						}
 					}
 				}
-				// Accumulate gradients for biases
-				gradBiases.Set(0, 0, gradBiases.At(0, 0)+outputGrad.At(outX, outY))
 			}
 		}
+	})
+}
 
-		// Update weights and biases using AdamW optimizer
-		fmt.Println("weights before:", cl.Weights[i])
-		for c := 0; c < len(cl._input); c++ {
-			cl.UpdateWeightsAndBiases(i, learningRate, gradWeights[c], gradBiases)
-		}
-		fmt.Println("weights after:", cl.Weights[i])
-	}
-	// resize gradOutput to have the same size as the input
-	*outputGrad = *ResizeMatrix(outputGrad, cl._input[0].RawMatrix().Rows, cl._input[0].RawMatrix().Cols)
+// Params returns the Weights and biases of the ConvLayer
+func (cl *ConvLayer) Params() []*Tensor {
+	return []*Tensor{cl.Weights, cl.Biases}
 }
 
-// UpdateWeightsAndBiases updates the Weights and biases of the convolutional layer using AdamW optimizer
-// This function gets called after all the gradients have been computed and accumulated.
-func (cl *ConvLayer) UpdateWeightsAndBiases(filterIndex int, learningRate float64, gradWeights, gradBiases *mat64.Dense) {
-	if learningRate == 0 {
-		// throw an error
-		errorString := "Learning rate cannot be zero"
-		panic(errorString)
-	}
-	// Compute AdamW updates for weights
-	cl.mWeights.Apply(func(i, j int, v float64) float64 {
-		return cl.beta1*v + (1-cl.beta1)*gradWeights.At(i, j)
-	}, cl.mWeights)
-	cl.vWeights.Apply(func(i, j int, v float64) float64 {
-		return cl.beta2*v + (1-cl.beta2)*gradWeights.At(i, j)*gradWeights.At(i, j)
-	}, cl.vWeights)
-	mHat := mat.NewDense(gradWeights.RawMatrix().Rows, gradWeights.RawMatrix().Cols, nil)
-	mHat.Apply(func(i, j int, v float64) float64 {
-		return v / (1 - math.Pow(cl.beta1, float64(cl.t+1)))
-	}, cl.mWeights)
-	vHat := mat.NewDense(gradWeights.RawMatrix().Rows, gradWeights.RawMatrix().Cols, nil)
-	vHat.Apply(func(i, j int, v float64) float64 {
-		return v / (1 - math.Pow(cl.beta2, float64(cl.t+1)))
-	}, cl.vWeights)
-	weightUpdate := mat.NewDense(gradWeights.RawMatrix().Rows, gradWeights.RawMatrix().Cols, nil)
-	for i := 0; i < gradWeights.RawMatrix().Rows; i++ {
-		for j := 0; j < gradWeights.RawMatrix().Cols; j++ {
-			weightUpdate.Set(i, j, learningRate*mHat.At(i, j)/(math.Sqrt(vHat.At(i, j))+cl.epsilon))
-		}
-	}
-	// Update weights
-	cl.Weights[filterIndex].Sub(cl.Weights[filterIndex], weightUpdate)
-
-	// Compute AdamW updates for biases
-	cl.mBiases.Apply(func(i, j int, v float64) float64 {
-		return cl.beta1*v + (1-cl.beta1)*gradBiases.At(i, j)
-	}, cl.mBiases)
-	cl.vBiases.Apply(func(i, j int, v float64) float64 {
-		return cl.beta2*v + (1-cl.beta2)*gradBiases.At(i, j)*gradBiases.At(i, j)
-	}, cl.vBiases)
-	mHatB := mat.NewDense(gradBiases.RawMatrix().Rows, gradBiases.RawMatrix().Cols, nil)
-	mHatB.Apply(func(i, j int, v float64) float64 {
-		return v / (1 - math.Pow(cl.beta1, float64(cl.t+1)))
-	}, cl.mBiases)
-	vHatB := mat.NewDense(gradBiases.RawMatrix().Rows, gradBiases.RawMatrix().Cols, nil)
-	vHatB.Apply(func(i, j int, v float64) float64 {
-		return v / (1 - math.Pow(cl.beta2, float64(cl.t+1)))
-	}, cl.vBiases)
-	biasUpdate := mat.NewDense(gradBiases.RawMatrix().Rows, gradBiases.RawMatrix().Cols, nil)
-	biasUpdate.Apply(func(i, j int, v float64) float64 {
-		return learningRate * v / (math.Sqrt(vHatB.At(i, j)) + cl.epsilon)
-	}, mHatB)
-
-	// Update biases
-	cl.Biases[filterIndex].Sub(cl.Biases[filterIndex], biasUpdate)
-
-	// Increment time step
-	cl.t++
+// Grads returns the accumulated gradients of the Weights and biases of the ConvLayer
+func (cl *ConvLayer) Grads() []*Tensor {
+	return []*Tensor{cl.gradWeights, cl.gradBiases}
 }
 
 // Summary returns a summary of the ConvLayer
@@ -226,5 +296,14 @@ func (cl *ConvLayer) Summary() string {
 	summary += fmt.Sprintf("    KernelSize: %d\n", cl.KernelSize)
 	summary += fmt.Sprintf("    InputChannels: %d\n", cl.InputChannels)
 	summary += fmt.Sprintf("    NumFilters: %d\n", cl.NumFilters)
+	if cl.Padding != "" {
+		summary += fmt.Sprintf("    Padding: %s\n", cl.Padding)
+	}
+	if cl.Stride != 1 {
+		summary += fmt.Sprintf("    Stride: %d\n", cl.Stride)
+	}
+	if cl.Dilation != 1 {
+		summary += fmt.Sprintf("    Dilation: %d\n", cl.Dilation)
+	}
 	return summary
 }
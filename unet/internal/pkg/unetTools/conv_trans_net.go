@@ -2,7 +2,7 @@ package unetTools
 
 import (
 	"fmt"
-	"math"
+	"math/rand"
 
 	"github.com/gonum/matrix/mat64"
 )
@@ -12,45 +12,61 @@ type ConvTransParams struct {
 	Activation    string
 	InputChannels int
 	KernelSize    int
-	Stride        int
+	Stride        int // step between input pixels in the output, 0 means 1
 	NumFilters    int
-	// and now for the AdamW optimizer
-	beta1   float64
-	beta2   float64
-	epsilon float64
+	WeightInit    string     // one of the Init* initializers, "" means InitUniform
+	BiasInit      string     // one of the Init* initializers, "" means InitUniform
+	Rand          *rand.Rand // source of the initial values, nil draws a seed from math/rand
 }
 
 // ConvTransLayer represents a transverse convolutional layer
 type ConvTransLayer struct {
-	Weights       []*mat64.Dense
-	Biases        []*mat64.Dense
+	Weights       *Tensor // NumFilters x InputChannels x KernelSize x KernelSize
+	Biases        *Tensor // NumFilters x 1 x 1 x 1
 	KernelSize    int
 	Stride        int
 	Activation    string
 	InputChannels int
 	NumFilters    int
-	// and now for the AdamW optimizer
-	beta1    float64
-	beta2    float64
-	epsilon  float64
-	mWeights *mat64.Dense
-	vWeights *mat64.Dense
-	mBiases  *mat64.Dense
-	vBiases  *mat64.Dense
-	t        int
-	_input   []*mat64.Dense
-	_output  []*mat64.Dense
+	activation    Activation // the function registered under Activation
+	_input        *Tensor
+	_preact       *Tensor // output before the activation function
+	_output       *Tensor
+
+	gradWeights *Tensor
+	gradBiases  *Tensor
 }
 
 // NewConvTransLayer initializes a new instance of ConvTransLayer
 func NewConvTransLayer(InputChannels, KernelSize, Stride, NumFilters int, Activation string) *ConvTransLayer {
-	// the number of filters determines the number of matrices used for the weights
-	Weights := make([]*mat64.Dense, NumFilters)
-	Biases := make([]*mat64.Dense, NumFilters)
-	for i := 0; i < NumFilters; i++ {
-		Weights[i] = mat64.NewDense(KernelSize, KernelSize, randomMatrixValues(KernelSize*KernelSize))
-		Biases[i] = mat64.NewDense(1, 1, randomMatrixValues(1))
+	return NewConvTransLayerFromParams(ConvTransParams{
+		Activation:    Activation,
+		InputChannels: InputChannels,
+		KernelSize:    KernelSize,
+		Stride:        Stride,
+		NumFilters:    NumFilters,
+	})
+}
+
+// NewConvTransLayerFromParams initializes a new instance of ConvTransLayer from params
+func NewConvTransLayerFromParams(params ConvTransParams) *ConvTransLayer {
+	InputChannels := params.InputChannels
+	KernelSize := params.KernelSize
+	Stride := params.Stride
+	if Stride == 0 {
+		Stride = 1
 	}
+	if Stride < 0 {
+		panic(fmt.Sprintf("ConvTransLayer stride must be positive, got %d", params.Stride))
+	}
+	NumFilters := params.NumFilters
+	Activation := params.Activation
+	// every filter has one kernel per input channel
+	rng := randOrGlobal(params.Rand)
+	Weights := NewTensor(NumFilters, InputChannels, KernelSize, KernelSize)
+	initTensor(Weights, params.WeightInit, InputChannels*KernelSize*KernelSize, NumFilters*KernelSize*KernelSize, rng)
+	Biases := NewTensor(NumFilters, 1, 1, 1)
+	initTensor(Biases, params.BiasInit, InputChannels*KernelSize*KernelSize, NumFilters*KernelSize*KernelSize, rng)
 	return &ConvTransLayer{
 		Weights:       Weights,
 		Biases:        Biases,
@@ -59,46 +75,78 @@ func NewConvTransLayer(InputChannels, KernelSize, Stride, NumFilters int, Activa
 		Activation:    Activation,
 		InputChannels: InputChannels,
 		NumFilters:    NumFilters,
-		// and now for the AdamW optimizer
-		beta1:    0.9,
-		beta2:    0.999,
-		epsilon:  1e-8,
-		mWeights: mat64.NewDense(KernelSize, KernelSize, nil),
-		vWeights: mat64.NewDense(KernelSize, KernelSize, nil),
-		mBiases:  mat64.NewDense(1, 1, nil),
-		vBiases:  mat64.NewDense(1, 1, nil),
-		t:        0,
+		activation:    mustActivation(Activation),
+
+		gradWeights: NewTensorLike(Weights),
+		gradBiases:  NewTensorLike(Biases),
 	}
 
 }
 
-// Forward performs a forward pass through the ConvTransLayer
-func (ctl *ConvTransLayer) Forward(input []*mat64.Dense) []*mat64.Dense {
-	copy(ctl._input, input)
-	layer_out := make([]*mat64.Dense, ctl.NumFilters)
-
-	for i := 0; i < ctl.NumFilters; i++ {
-		for j := 0; j < len(input); j++ {
-			if j == 0 {
-				layer_out[i] = ctl.TransverseConvolve(input[i], ctl.Weights[i], ctl.Biases[i])
-			} else {
-				layer_out[i].Add(layer_out[i], ctl.TransverseConvolve(input[i], ctl.Weights[i], ctl.Biases[i]))
-			}
-		}
-		layer_out[i].Scale(1.0/float64(len(input)), layer_out[i])
-		applyActivation(layer_out[i], ctl.Activation)
+// Forward performs a forward pass through the ConvTransLayer.
+// Every filter sums the transverse convolutions of all input channels
+// with its per-channel kernels, then adds its bias once per output pixel.
+func (ctl *ConvTransLayer) Forward(input *Tensor) *Tensor {
+	n, c, h, w := input.Dims()
+	if c != ctl.InputChannels {
+		panic(fmt.Sprintf("ConvTransLayer expects %d input channels, got %d", ctl.InputChannels, c))
 	}
+	ctl._input = input
+	layer_out := NewTensor(n, ctl.NumFilters, (h-1)*ctl.Stride+ctl.KernelSize, (w-1)*ctl.Stride+ctl.KernelSize)
+
+	// every output plane is independent of the others
+	parallelFor(n*ctl.NumFilters, func(u int) {
+		s, i := u/ctl.NumFilters, u%ctl.NumFilters
+		out := layer_out.Channel(s, i)
+		for j := 0; j < c; j++ {
+			ctl.transverseConvolveAdd(out, input.Channel(s, j), ctl.Weights.Channel(i, j))
+		}
+		bias := ctl.Biases.At(i, 0, 0, 0)
+		for k, v := range layer_out.Plane(s, i) {
+			layer_out.Plane(s, i)[k] = v + bias
+		}
+	})
+
+	ctl._preact = layer_out.Clone()
+	parallelFor(n*ctl.NumFilters, func(u int) {
+		applyActivation(layer_out.Plane(u/ctl.NumFilters, u%ctl.NumFilters), ctl.activation)
+	})
 
-	// Apply Activation function
-	copy(ctl._output, layer_out)
+	ctl._output = layer_out
 	return layer_out
 }
 
+// Convolve performs a convolution of input with a matrix of size
+// ctl.KernelSize x ctl.KernelSize and stride ctl.Stride.
+// It is the adjoint of TransverseConvolve, and bias may be nil.
 func (ctl *ConvTransLayer) Convolve(input, kernel, bias *mat64.Dense) *mat64.Dense {
-	// performs a convolution with a matrix of size ctl.KernelSize x ctl.KernelSize
-	// and stride ctl.Stride
+	in_rows, in_cols := input.Dims()
+	out_rows := (in_rows-ctl.KernelSize)/ctl.Stride + 1
+	out_cols := (in_cols-ctl.KernelSize)/ctl.Stride + 1
+	output := mat64.NewDense(out_rows, out_cols, nil)
+
+	b := 0.0
+	if bias != nil {
+		b = bias.At(0, 0)
+	}
+	for i := 0; i < out_rows; i++ {
+		in_i := i * ctl.Stride
+		for j := 0; j < out_cols; j++ {
+			in_j := j * ctl.Stride
+			sum := 0.0
+			for k := 0; k < ctl.KernelSize; k++ {
+				for l := 0; l < ctl.KernelSize; l++ {
+					sum += kernel.At(k, l) * input.At(in_i+k, in_j+l)
+				}
+			}
+			output.Set(i, j, sum+b)
+		}
+	}
+	return output
 }
 
+// TransverseConvolve performs a transverse convolution of a single channel
+// with kernel and stride ctl.Stride, adding bias once per output pixel
 func (ctl *ConvTransLayer) TransverseConvolve(input, kernel, bias *mat64.Dense) *mat64.Dense {
 	// output size
 	in_rows, in_cols := input.Dims()
@@ -106,102 +154,109 @@ func (ctl *ConvTransLayer) TransverseConvolve(input, kernel, bias *mat64.Dense)
 	out_cols := (in_cols-1)*ctl.Stride + ctl.KernelSize
 	// create output
 	output := mat64.NewDense(out_rows, out_cols, nil)
+	ctl.transverseConvolveAdd(output, input, kernel)
 
-	// fill output
+	b := bias.At(0, 0)
+	output.Apply(func(_, _ int, v float64) float64 {
+		return v + b
+	}, output)
+	return output
+}
+
+// transverseConvolveAdd adds the transverse convolution of input with kernel to output
+func (ctl *ConvTransLayer) transverseConvolveAdd(output, input, kernel *mat64.Dense) {
+	in_rows, in_cols := input.Dims()
 	for i := 0; i < in_rows; i++ {
 		out_i := i * ctl.Stride
 		for j := 0; j < in_cols; j++ {
 			out_j := j * ctl.Stride
-			// copy input value to output
+			value := input.At(i, j)
+			if value == 0 {
+				continue
+			}
+			// spread the input value over the kernel footprint
 			for k := 0; k < ctl.KernelSize; k++ {
 				for l := 0; l < ctl.KernelSize; l++ {
-					value := output.At(out_i+k, out_j+l)
-					value += kernel.At(k, l) * input.At(i, j)
-					output.Set(out_i+k, out_j+l, value+bias.At(0, 0))
+					output.Set(out_i+k, out_j+l, output.At(out_i+k, out_j+l)+kernel.At(k, l)*value)
 				}
 			}
 		}
 	}
-	return output
 }
 
-// Backward computes the backward pass of the convolutional layer.
-// It takes the gradient of the output matrix as input,
-// and returns the gradient of the input matrix, the gradient of the Weights matrix,
-// and the gradient of the biases matrix.
-func (ctl *ConvTransLayer) Backward(gradOutput *mat64.Dense, learningRate float64) {
+// Backward computes the backward pass of the transverse convolutional layer.
+// It takes the gradient of the output as input, accumulates the gradients
+// of the Weights and biases, and returns the gradient of the input.
+//
+// The gradient of the input is the strided convolution (Convolve) of the
+// pre-activation gradient with each kernel, summed over the filters.
+func (ctl *ConvTransLayer) Backward(gradOutput *Tensor) *Tensor {
+	mustSameShape("ConvTransLayer.Backward", gradOutput, ctl._output)
+	numSamples, numChannels, inputRows, inputCols := ctl._input.Dims()
 
-	// iterate over all filters
-	// for i := 0; i < ctl.NumFilters; i++ {
-	// 	// compute the gradient of the input
-	// 	gradInput := mat64.NewDense(ctl.InputChannels, ctl.KernelSize, nil)
-	// 	gradInput = ctl.TransverseConvolve(gradOutput, ctl.Weights[i], mat64.NewDense(1, 1, nil))
+	// gradient with respect to the pre-activation values
+	gradPreact := gradOutput.Clone()
+	for i, v := range ctl._preact.Data {
+		gradPreact.Data[i] *= ctl.activation.Derivative(v)
+	}
 
-	// 	// compute the gradient of the weights
-	// 	var gradWeights []*mat64.Dense
-	// 	for j := 0; j < ctl.InputChannels; j++ {
+	// the parameter gradients are split by filter
+	parallelFor(ctl.NumFilters, func(i int) {
+		for s := 0; s < numSamples; s++ {
+			grad := gradPreact.Channel(s, i)
 
-	// 	}
-	gradOutput =
+			// every output pixel receives the bias once
+			bias := ctl.gradBiases.Plane(i, 0)
+			for _, v := range gradPreact.Plane(s, i) {
+				bias[0] += v
+			}
 
+			for c := 0; c < numChannels; c++ {
+				// gradient of the weights: every kernel element k, l saw
+				// input(a, b) at output position (a*Stride+k, b*Stride+l)
+				gradWeights := ctl.gradWeights.Channel(i, c)
+				for k := 0; k < ctl.KernelSize; k++ {
+					for l := 0; l < ctl.KernelSize; l++ {
+						sum := 0.0
+						for a := 0; a < inputRows; a++ {
+							for b := 0; b < inputCols; b++ {
+								sum += ctl._input.At(s, c, a, b) * grad.At(a*ctl.Stride+k, b*ctl.Stride+l)
+							}
+						}
+						gradWeights.Set(k, l, gradWeights.At(k, l)+sum)
+					}
+				}
+			}
+		}
+	})
+
+	// the input gradient is split by sample and channel
+	gradInput := NewTensorLike(ctl._input)
+	parallelFor(numSamples*numChannels, func(u int) {
+		s, c := u/numChannels, u%numChannels
+		in := gradInput.Channel(s, c)
+		for i := 0; i < ctl.NumFilters; i++ {
+			in.Add(in, ctl.Convolve(gradPreact.Channel(s, i), ctl.Weights.Channel(i, c), nil))
+		}
+	})
+	return gradInput
 }
 
-// UpdateWeightsAndBiases updates the Weights and biases of the convolutional layer using AdamW optimizer
-// This function gets called after all the gradients have been computed and accumctlated.
-func (ctl *ConvTransLayer) UpdateWeightsAndBiases(learningRate float64, gradWeights, gradBiases *mat64.Dense) {
-	// Increment time step
-	ctl.t++
-
-	// Compute biased first and second moment estimates
-	ctl.mWeights.Scale(ctl.beta1, ctl.mWeights)
-	gradWeights.Scale(1-ctl.beta1, gradWeights)
-	ctl.mWeights.Add(ctl.mWeights, gradWeights)
-	ctl.vWeights.Scale(ctl.beta2, ctl.vWeights)
-	gradWeightsPow2 := mat64.NewDense(gradWeights.RawMatrix().Rows, gradWeights.RawMatrix().Cols, nil)
-	gradWeightsPow2.MulElem(gradWeights, gradWeights)
-	gradWeightsPow2.Scale(1-ctl.beta2, gradWeightsPow2)
-	ctl.vWeights.Add(ctl.vWeights, gradWeightsPow2)
-
-	ctl.mBiases.Scale(ctl.beta1, ctl.mBiases)
-	gradBiases.Scale(1-ctl.beta1, gradBiases)
-	ctl.mBiases.Add(ctl.mBiases, gradBiases)
-	ctl.vBiases.Scale(ctl.beta2, ctl.vBiases)
-	gradBiasesPow2 := mat64.NewDense(gradBiases.RawMatrix().Rows, gradBiases.RawMatrix().Cols, nil)
-	gradBiasesPow2.MulElem(gradBiases, gradBiases)
-	gradBiasesPow2.Scale(1-ctl.beta2, gradBiasesPow2)
-	ctl.vBiases.Add(ctl.vBiases, gradBiasesPow2)
-
-	// Correct bias in the first moment
-	mWeightsCorrected := mat64.NewDense(ctl.mWeights.RawMatrix().Rows, ctl.mWeights.RawMatrix().Cols, nil)
-	mWeightsCorrected.Scale(1.0/(1-math.Pow(ctl.beta1, float64(ctl.t))), ctl.mWeights)
-
-	mBiasesCorrected := mat64.NewDense(ctl.mBiases.RawMatrix().Rows, ctl.mBiases.RawMatrix().Cols, nil)
-	mBiasesCorrected.Scale(1.0/(1-math.Pow(ctl.beta1, float64(ctl.t))), ctl.mBiases)
-
-	// Correct bias in the second moment
-	vWeightsCorrected := mat64.NewDense(ctl.vWeights.RawMatrix().Rows, ctl.vWeights.RawMatrix().Cols, nil)
-	vWeightsCorrected.Scale(1.0/(1-math.Pow(ctl.beta2, float64(ctl.t))), ctl.vWeights)
-
-	vBiasesCorrected := mat64.NewDense(ctl.vBiases.RawMatrix().Rows, ctl.vBiases.RawMatrix().Cols, nil)
-	vBiasesCorrected.Scale(1.0/(1-math.Pow(ctl.beta2, float64(ctl.t))), ctl.vBiases)
-
-	// Update Weights
-	for _, weight_mat := range ctl.Weights {
-		mWeightsCorrected.MulElem(mWeightsCorrected, ConstDivMatrix(learningRate, MatrixAddConst(MatrixSqrt(vWeightsCorrected), ctl.epsilon)))
-		weight_mat.Sub(weight_mat, mWeightsCorrected)
-	}
+// Params returns the Weights and biases of the ConvTransLayer
+func (ctl *ConvTransLayer) Params() []*Tensor {
+	return []*Tensor{ctl.Weights, ctl.Biases}
+}
 
-	// Update biases
-	for _, bias_mat := range ctl.Biases {
-		mBiasesCorrected.MulElem(mBiasesCorrected, ConstDivMatrix(learningRate, MatrixAddConst(MatrixSqrt(vBiasesCorrected), ctl.epsilon)))
-		bias_mat.Sub(bias_mat, mBiasesCorrected)
-	}
+// Grads returns the accumulated gradients of the Weights and biases of the ConvTransLayer
+func (ctl *ConvTransLayer) Grads() []*Tensor {
+	return []*Tensor{ctl.gradWeights, ctl.gradBiases}
 }
 
 // Summary returns a summary of the ConvTransLayer
 func (ctl *ConvTransLayer) Summary() string {
 	summary := fmt.Sprintf("    Activation: %s\n", ctl.Activation)
 	summary += fmt.Sprintf("    KernelSize: %d\n", ctl.KernelSize)
+	summary += fmt.Sprintf("    Stride: %d\n", ctl.Stride)
 	summary += fmt.Sprintf("    InputChannels: %d\n", ctl.InputChannels)
 	summary += fmt.Sprintf("    NumFilters: %d\n", ctl.NumFilters)
 	return summary
@@ -0,0 +1,206 @@
+package unetTools
+
+import (
+	"fmt"
+	"math"
+)
+
+// probEpsilon bounds the probabilities away from 0 and 1 before their log is taken
+const probEpsilon = 1e-7
+
+// ClassOptions are the per-class options of the cross-entropy and focal
+// losses. The class of an element is its target (0 or 1) for the binary
+// losses, and the channel of its pixel's target (the one holding the largest
+// value) for the categorical ones. The loss is the weighted mean over the
+// elements, sum(w * l) / sum(w), so weights rebalance the classes without
+// changing the scale of the loss.
+type ClassOptions struct {
+	Weights     []float64 // Weight of every class, nil weighs every class 1
+	Ignore      bool      // Leave the elements of class IgnoreIndex out of the loss
+	IgnoreIndex int       // Class left out of the loss; for the binary losses any target equal to it
+}
+
+// weight returns the weight of class, out of numClasses
+func (o ClassOptions) weight(class, numClasses int) float64 {
+	if o.Ignore && class == o.IgnoreIndex {
+		return 0
+	}
+	if o.Weights == nil {
+		return 1
+	}
+	if len(o.Weights) != numClasses {
+		panic(fmt.Sprintf("got %d class weights for %d classes", len(o.Weights), numClasses))
+	}
+	return o.Weights[class]
+}
+
+// binaryWeight returns the weight of an element with target t
+func (o ClassOptions) binaryWeight(t float64) float64 {
+	if o.Ignore && t == float64(o.IgnoreIndex) {
+		return 0
+	}
+	if t >= 0.5 {
+		return o.weight(1, 2)
+	}
+	return o.weight(0, 2)
+}
+
+// clampProb bounds p to [probEpsilon, 1 - probEpsilon], and reports whether
+// it had to. Where it does, the loss no longer depends on p, so its gradient
+// is 0.
+func clampProb(p float64) (float64, bool) {
+	clamped := math.Min(math.Max(p, probEpsilon), 1-probEpsilon)
+	return clamped, clamped != p
+}
+
+// binaryLoss computes the weighted mean of a loss over every element and,
+// if grad is not nil, its gradient. elementLoss returns the loss of one
+// element and its derivative with respect to the prediction.
+func binaryLoss(
+	name string,
+	pred, target *Tensor,
+	options ClassOptions,
+	grad *Tensor,
+	elementLoss func(p, t float64) (float64, float64),
+) float64 {
+	mustSameShape(name, pred, target)
+	sum, total := 0.0, 0.0
+	for i, p := range pred.Data {
+		t := target.Data[i]
+		w := options.binaryWeight(t)
+		if w == 0 {
+			continue
+		}
+		l, dl := elementLoss(p, t)
+		sum += w * l
+		total += w
+		if grad != nil {
+			grad.Data[i] = w * dl
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	if grad != nil {
+		grad.Scale(1 / total)
+	}
+	return sum / total
+}
+
+// BinaryCrossEntropy is the binary cross-entropy, -(t*log(p) + (1-t)*log(1-p)),
+// of every element. With FromLogits the prediction holds logits rather than
+// probabilities: the sigmoid is folded into the loss, which then stays exact
+// for any logit and has the gradient sigmoid(z) - t. Without it, the
+// probabilities are clamped away from 0 and 1, and the gradient is 0 where
+// the clamp applies.
+type BinaryCrossEntropy struct {
+	ClassOptions
+	FromLogits bool
+}
+
+// NewBinaryCrossEntropy initializes a new instance of BinaryCrossEntropy
+func NewBinaryCrossEntropy(fromLogits bool, options ClassOptions) BinaryCrossEntropy {
+	return BinaryCrossEntropy{ClassOptions: options, FromLogits: fromLogits}
+}
+
+// element returns the loss of one element and its derivative
+func (bce BinaryCrossEntropy) element(x, t float64) (float64, float64) {
+	if bce.FromLogits {
+		// log(1 + e^x) - t*x, written to not overflow
+		return Softplus{}.Forward(x) - t*x, sigmoid(x) - t
+	}
+	p, clamped := clampProb(x)
+	loss := -(t*math.Log(p) + (1-t)*math.Log(1-p))
+	if clamped {
+		return loss, 0
+	}
+	return loss, (p - t) / (p * (1 - p))
+}
+
+// Value returns the binary cross-entropy of pred
+func (bce BinaryCrossEntropy) Value(pred, target *Tensor) float64 {
+	return binaryLoss("BinaryCrossEntropy", pred, target, bce.ClassOptions, nil, bce.element)
+}
+
+// Grad returns the gradient of the binary cross-entropy with respect to pred
+func (bce BinaryCrossEntropy) Grad(pred, target *Tensor) *Tensor {
+	grad := NewTensorLike(pred)
+	binaryLoss("BinaryCrossEntropy", pred, target, bce.ClassOptions, grad, bce.element)
+	return grad
+}
+
+// CategoricalCrossEntropy is the categorical cross-entropy, -sum_c t_c*log(p_c),
+// of every pixel, with the classes along the channels. The prediction must
+// hold a probability distribution over the channels at every pixel, as a
+// SoftmaxLayer produces, and the target one-hot (or soft) labels. Pixels
+// whose target is all zeros, such as the ones OneHot builds for labels out
+// of range, are left out of the loss. The probabilities are clamped away
+// from 0 before their log is taken, and the gradient is 0 where the clamp
+// applies.
+type CategoricalCrossEntropy struct {
+	ClassOptions
+}
+
+// NewCategoricalCrossEntropy initializes a new instance of CategoricalCrossEntropy
+func NewCategoricalCrossEntropy(options ClassOptions) CategoricalCrossEntropy {
+	return CategoricalCrossEntropy{ClassOptions: options}
+}
+
+// loss returns the categorical cross-entropy of pred and, if grad is not
+// nil, fills it with its gradient
+func (cce CategoricalCrossEntropy) loss(pred, target, grad *Tensor) float64 {
+	mustSameShape("CategoricalCrossEntropy", pred, target)
+	_, channels, rows, cols := pred.Dims()
+	planeSize := rows * cols
+	sum, total := 0.0, 0.0
+	for i := 0; i < planeSize; i++ {
+		// the class of the pixel is the channel of its largest target;
+		// a pixel without any positive target is unlabelled and left out
+		class := 0
+		for c := 1; c < channels; c++ {
+			if target.Data[c*planeSize+i] > target.Data[class*planeSize+i] {
+				class = c
+			}
+		}
+		if target.Data[class*planeSize+i] <= 0 {
+			continue
+		}
+		w := cce.weight(class, channels)
+		if w == 0 {
+			continue
+		}
+		total += w
+		for c := 0; c < channels; c++ {
+			t := target.Data[c*planeSize+i]
+			if t == 0 {
+				continue
+			}
+			p := math.Max(pred.Data[c*planeSize+i], probEpsilon)
+			sum -= w * t * math.Log(p)
+			// where p is clamped the loss is flat
+			if grad != nil && p == pred.Data[c*planeSize+i] {
+				grad.Data[c*planeSize+i] = -w * t / p
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	if grad != nil {
+		grad.Scale(1 / total)
+	}
+	return sum / total
+}
+
+// Value returns the categorical cross-entropy of pred
+func (cce CategoricalCrossEntropy) Value(pred, target *Tensor) float64 {
+	return cce.loss(pred, target, nil)
+}
+
+// Grad returns the gradient of the categorical cross-entropy with respect to
+// pred. Through the SoftmaxLayer it becomes the familiar p - t.
+func (cce CategoricalCrossEntropy) Grad(pred, target *Tensor) *Tensor {
+	grad := NewTensorLike(pred)
+	cce.loss(pred, target, grad)
+	return grad
+}
@@ -16,28 +16,32 @@ type DecoderParams struct {
 type Decoder struct {
 	convParams     []ConvParams
 	upsampleParams []ConvTransParams
-	convLayers     []*ConvLayer
-	upsampleLayers []*ConvTransLayer
+	convLayers     []Layer
+	upsampleLayers []Layer
 
 	// internal params
 	_dWeights []*mat64.Dense
 	_dBiases  []*mat64.Dense
+	_upChans  int // number of upsampled channels in front of the skip features
+	_skipRows int // size of the skip features before resizing
+	_skipCols int
 }
 
 // NewDecoder initializes a new instance of Decoder
 func NewDecoder(convParams []ConvParams, upsampleParams []ConvTransParams) *Decoder {
-	decoder := &Decoder{}
-
 	// Create upsampling layers
+	var upsampleLayers []Layer
 	for _, params := range upsampleParams {
-		decoder.upsampleLayers = append(decoder.upsampleLayers, NewConvTransLayer(params.InputChannels, params.KernelSize, params.Stride, params.NumFilters, params.Activation))
+		upsampleLayers = append(upsampleLayers, NewConvTransLayerFromParams(params))
 	}
 
 	// Create convolutional layers
+	var convLayers []Layer
 	for _, params := range convParams {
-		decoder.convLayers = append(decoder.convLayers, NewConvLayer(params.InputChannels, params.KernelSize, params.NumFilters, params.Activation))
+		convLayers = append(convLayers, NewConvLayerFromParams(params))
 	}
 
+	decoder := NewDecoderFromLayers(upsampleLayers, convLayers)
 	decoder.convParams = convParams
 	decoder.upsampleParams = upsampleParams
 
@@ -47,44 +51,81 @@ func NewDecoder(convParams []ConvParams, upsampleParams []ConvTransParams) *Deco
 	return decoder
 }
 
+// NewDecoderFromLayers initializes a new instance of Decoder from existing layers.
+// The upsampling layers are applied first, then the skip features are concatenated,
+// followed by the convolutional layers.
+func NewDecoderFromLayers(upsampleLayers []Layer, convLayers []Layer) *Decoder {
+	return &Decoder{
+		upsampleLayers: upsampleLayers,
+		convLayers:     convLayers,
+	}
+}
+
 // Forward performs a forward pass through the Decoder
-func (dec *Decoder) Forward(input []*mat64.Dense, skip_features []*mat64.Dense) []*mat64.Dense {
+func (dec *Decoder) Forward(input *Tensor, skip_features *Tensor) *Tensor {
+	return dec.forward(nil, input, skip_features)
+}
+
+// forward performs a forward pass through the Decoder, recording every operation on tape
+func (dec *Decoder) forward(tape *Tape, input *Tensor, skip_features *Tensor) *Tensor {
 	// upsample input
 	for _, upsampleLayer := range dec.upsampleLayers {
 		// Forward pass through upsampling layer
-		input = upsampleLayer.Forward(input)
+		input = tape.Layer(upsampleLayer, input)
 	}
 
 	// concatenate with skip features
 	// if there are no skip features, then just return the output
+	dec._upChans = 0
 	if skip_features != nil {
-		// resize skip_features to have the same size as the output
-		rows, cols := input[0].Dims()
-		for i, skip_feature := range skip_features {
-			skip_features[i] = ResizeMatrix(skip_feature, rows, cols)
+		// resize skip_features to have the same size as the output,
+		// which is only needed when the convolutions are not padded
+		_, _, dec._skipRows, dec._skipCols = skip_features.Dims()
+		_, _, rows, cols := input.Dims()
+		if rows != dec._skipRows || cols != dec._skipCols {
+			skip_features = tape.Resize(skip_features, rows, cols)
 		}
 
 		// concatenate the output with the skip_features
-		input = append(input, skip_features...)
+		_, dec._upChans, _, _ = input.Dims()
+		input = tape.Concat(input, skip_features)
 	}
 	// pass through convolutional layers
 	for _, conv := range dec.convLayers {
-		input = conv.Forward(input)
+		input = tape.Layer(conv, input)
 	}
 	return input
 }
 
-// Backward performs a backward pass through the Decoder
-func (dec *Decoder) Backward(outputGrad *mat64.Dense, learningRate float64) {
-	// Declare variables for accumulating gradients
+// Backward performs a backward pass through the Decoder.
+// It returns the gradient of the Decoder's input and the gradient of the
+// skip features, which is nil if the last Forward had no skip features.
+func (dec *Decoder) Backward(gradOutput *Tensor) (*Tensor, *Tensor) {
 	for i := len(dec.convLayers) - 1; i >= 0; i-- {
 		// Backward pass through convolutional layer
-		dec.convLayers[i].Backward(outputGrad, learningRate)
+		gradOutput = dec.convLayers[i].Backward(gradOutput)
+	}
+	var gradSkip *Tensor
+	if dec._upChans > 0 {
+		// split the gradient at the concatenation point and undo the resize
+		gradOutput, gradSkip = SplitChannels(gradOutput, dec._upChans)
+		gradSkip = ResizeTensorBackward(gradSkip, dec._skipRows, dec._skipCols)
 	}
 	for i := len(dec.upsampleLayers) - 1; i >= 0; i-- {
 		// Backward pass through upsampling layer
-		dec.upsampleLayers[i].Backward(outputGrad, learningRate)
+		gradOutput = dec.upsampleLayers[i].Backward(gradOutput)
 	}
+	return gradOutput, gradSkip
+}
+
+// Params returns the parameters of every layer in the Decoder
+func (dec *Decoder) Params() []*Tensor {
+	return append(collectParams(dec.upsampleLayers), collectParams(dec.convLayers)...)
+}
+
+// Grads returns the gradients of every layer in the Decoder
+func (dec *Decoder) Grads() []*Tensor {
+	return append(collectGrads(dec.upsampleLayers), collectGrads(dec.convLayers)...)
 }
 
 // Summary prints a summary of the Decoder
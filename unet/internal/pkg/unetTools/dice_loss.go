@@ -1,51 +1,160 @@
 package unetTools
 
 import (
-	"math"
+	"fmt"
 
 	mat "github.com/gonum/matrix/mat64"
 )
 
-// DiceLoss calculates the Dice loss between two binary masks
+// Dice is the soft Dice loss, 1 - (2 * sum(p*t) + Smooth) / (sum(p) + sum(t) + Smooth),
+// taken over every element of the prediction. Unlike DiceLoss it does not
+// threshold the prediction, so it is differentiable. Smooth keeps the loss
+// defined, and equal to 0, when both the prediction and the target are empty.
+type Dice struct {
+	Smooth float64
+}
+
+// NewDice initializes a new instance of Dice
+func NewDice(smooth float64) Dice {
+	return Dice{Smooth: smooth}
+}
+
+// diceSums returns sum(p*t), sum(p) and sum(t)
+func diceSums(pred, target []float64) (intersection, predSum, targetSum float64) {
+	for i, p := range pred {
+		intersection += p * target[i]
+		predSum += p
+		targetSum += target[i]
+	}
+	return intersection, predSum, targetSum
+}
+
+// Value returns the soft Dice loss of pred
+func (d Dice) Value(pred, target *Tensor) float64 {
+	mustSameShape("Dice", pred, target)
+	intersection, predSum, targetSum := diceSums(pred.Data, target.Data)
+	return 1 - (2*intersection+d.Smooth)/(predSum+targetSum+d.Smooth)
+}
+
+// Grad returns the gradient of the soft Dice loss with respect to pred:
+// -(2 * t * (sum(p) + sum(t) + Smooth) - (2 * sum(p*t) + Smooth)) / (sum(p) + sum(t) + Smooth)^2
+func (d Dice) Grad(pred, target *Tensor) *Tensor {
+	mustSameShape("Dice", pred, target)
+	intersection, predSum, targetSum := diceSums(pred.Data, target.Data)
+	numerator := 2*intersection + d.Smooth
+	denominator := predSum + targetSum + d.Smooth
+	grad := NewTensorLike(pred)
+	for i, t := range target.Data {
+		grad.Data[i] = -(2*t*denominator - numerator) / (denominator * denominator)
+	}
+	return grad
+}
+
+// GeneralizedDice is the generalized Dice loss over the channels (classes)
+// of the prediction:
+//
+//	1 - (2 * sum_c w_c * sum(p_c*t_c) + Smooth) / (sum_c w_c * (sum(p_c) + sum(t_c)) + Smooth)
+//
+// Weights holds w_c for every channel. If it is nil, every class is weighted
+// by 1 / sum(t_c)^2, so that small foreground classes count as much as the
+// background, and classes absent from the target get no weight. If no class
+// has any weight left, as for an all-background target with Smooth 0, the
+// loss is 0 and so is its gradient.
+type GeneralizedDice struct {
+	Weights []float64
+	Smooth  float64
+}
+
+// NewGeneralizedDice initializes a new instance of GeneralizedDice
+func NewGeneralizedDice(weights []float64, smooth float64) GeneralizedDice {
+	return GeneralizedDice{Weights: weights, Smooth: smooth}
+}
+
+// sums returns the weighted numerator and denominator of the Dice
+// coefficient, and the weight of every channel
+func (d GeneralizedDice) sums(pred, target *Tensor) (numerator, denominator float64, weights []float64) {
+	mustSameShape("GeneralizedDice", pred, target)
+	samples, channels, _, _ := pred.Dims()
+	if samples != 1 {
+		panic(fmt.Sprintf("GeneralizedDice compares one sample at a time, got %d", samples))
+	}
+	if d.Weights != nil && len(d.Weights) != channels {
+		panic(fmt.Sprintf("GeneralizedDice has %d weights for %d channels", len(d.Weights), channels))
+	}
+	weights = make([]float64, channels)
+	for c := 0; c < channels; c++ {
+		intersection, predSum, targetSum := diceSums(pred.Plane(0, c), target.Plane(0, c))
+		if d.Weights != nil {
+			weights[c] = d.Weights[c]
+		} else if targetSum > 0 {
+			weights[c] = 1 / (targetSum * targetSum)
+		}
+		numerator += 2 * weights[c] * intersection
+		denominator += weights[c] * (predSum + targetSum)
+	}
+	return numerator + d.Smooth, denominator + d.Smooth, weights
+}
+
+// Value returns the generalized Dice loss of pred
+func (d GeneralizedDice) Value(pred, target *Tensor) float64 {
+	numerator, denominator, _ := d.sums(pred, target)
+	if denominator == 0 {
+		return 0
+	}
+	return 1 - numerator/denominator
+}
+
+// Grad returns the gradient of the generalized Dice loss with respect to pred:
+// -w_c * (2 * t * denominator - numerator) / denominator^2
+func (d GeneralizedDice) Grad(pred, target *Tensor) *Tensor {
+	numerator, denominator, weights := d.sums(pred, target)
+	grad := NewTensorLike(pred)
+	if denominator == 0 {
+		return grad
+	}
+	planeSize := pred.Shape[2] * pred.Shape[3]
+	for i, t := range target.Data {
+		w := weights[i/planeSize]
+		grad.Data[i] = -w * (2*t*denominator - numerator) / (denominator * denominator)
+	}
+	return grad
+}
+
+// DiceLoss calculates the Dice loss between two binary masks. The prediction
+// is thresholded at 0.9, so this is a metric to report rather than a loss to
+// train with: use Dice for training.
 func DiceLoss(prediction, target *mat.Dense) float64 {
 	// Convert prediction and target masks to slices
 	pred := prediction.RawMatrix().Data
 	targ := target.RawMatrix().Data
 
-	// Initialize variables for intersection and union
+	// Initialize variables for intersection and mask sizes
 	intersection := 0.0
-	union := 0.0
+	predSize := 0.0
+	targSize := 0.0
 
-	// Calculate intersection and union
+	// Calculate intersection and mask sizes
 	for i := 0; i < len(pred); i++ {
 		if pred[i] >= 0.9 && targ[i] == 1 {
 			intersection++
 		}
-		if pred[i] >= 0.9 || targ[i] == 1 {
-			union++
+		if pred[i] >= 0.9 {
+			predSize++
 		}
+		if targ[i] == 1 {
+			targSize++
+		}
+	}
+	if predSize+targSize == 0 {
+		// both masks are empty, so they match
+		return 0
 	}
 
 	// Calculate Dice coefficient
-	dice := 2.0 * intersection / (union + intersection)
+	dice := 2.0 * intersection / (predSize + targSize)
 
 	// Calculate Dice loss
 	diceLoss := 1.0 - dice
 
 	return diceLoss
 }
-
-// DiceLossGradient calculates the gradient of the Dice loss between two binary masks
-func DiceLossGradient(prediction, target *mat.Dense) *mat.Dense {
-	// Compute sums of prediction and target masks
-	p := mat.Sum(target)
-	q := mat.Sum(prediction)
-
-	// Compute the gradient of the Dice Loss
-	gradient := mat.NewDense(target.RawMatrix().Rows, prediction.RawMatrix().Cols, nil)
-	gradient.Apply(func(_, _ int, v float64) float64 {
-		return -2 * v / math.Pow(p+q, 2)
-	}, target)
-
-	return gradient
-}
@@ -14,8 +14,8 @@ type EncoderParams struct {
 
 // Encoder represents an encoder for convolutional neural networks
 type Encoder struct {
-	convLayers []*ConvLayer
-	poolLayers []*MaxPoolLayer
+	convLayers []Layer
+	poolLayers []Layer
 
 	// internal params
 	_dWeights []*mat64.Dense
@@ -24,53 +24,96 @@ type Encoder struct {
 
 // NewEncoder initializes a new instance of Encoder
 func NewEncoder(convParams []ConvParams, poolParams []PoolParams) *Encoder {
-	encoder := &Encoder{}
-
 	// Create convolutional layers
-	encoder.convLayers = make([]*ConvLayer, len(convParams))
+	convLayers := make([]Layer, len(convParams))
 	for i, params := range convParams {
-		encoder.convLayers[i] = NewConvLayer(
-			params.InputChannels,
-			params.KernelSize,
-			params.NumFilters,
-			params.Activation,
-		)
+		convLayers[i] = NewConvLayerFromParams(params)
 	}
 
 	// Create pooling layer
-	encoder.poolLayers = make([]*MaxPoolLayer, len(poolParams))
+	poolLayers := make([]Layer, len(poolParams))
 	for i, params := range poolParams {
-		encoder.poolLayers[i] = NewMaxPoolLayer(params.PoolSize, params.Stride)
+		poolLayers[i] = NewMaxPoolLayer(params.PoolSize, params.Stride)
 	}
 
+	encoder := NewEncoderFromLayers(convLayers, poolLayers)
 	encoder._dWeights = make([]*mat64.Dense, len(convParams))
 	encoder._dBiases = make([]*mat64.Dense, len(convParams))
 
 	return encoder
 }
 
+// NewEncoderFromLayers initializes a new instance of Encoder from existing layers.
+// The convolutional layers are applied first, followed by the pooling layers.
+func NewEncoderFromLayers(convLayers []Layer, poolLayers []Layer) *Encoder {
+	return &Encoder{
+		convLayers: convLayers,
+		poolLayers: poolLayers,
+	}
+}
+
 // Forward performs a forward pass through the Encoder
-func (enc *Encoder) Forward(input []*mat64.Dense) []*mat64.Dense {
+func (enc *Encoder) Forward(input *Tensor) *Tensor {
+	output, _ := enc.forward(nil, input)
+	return output
+}
+
+// ForwardWithSkip performs a forward pass through the Encoder and also returns
+// the skip features, i.e. the output of the convolutional layers before pooling
+func (enc *Encoder) ForwardWithSkip(input *Tensor) (*Tensor, *Tensor) {
+	return enc.forward(nil, input)
+}
+
+// forward performs a forward pass through the Encoder, recording every layer on tape.
+// It returns the pooled output and the skip features.
+func (enc *Encoder) forward(tape *Tape, input *Tensor) (*Tensor, *Tensor) {
 	for _, convLayer := range enc.convLayers {
 		// Forward pass through convolutional layer
-		input = convLayer.Forward(input)
+		input = tape.Layer(convLayer, input)
 	}
+	skip := input
 	// Forward pass through pooling layer (there should only ever be 1)
 	for _, poolLayer := range enc.poolLayers {
-		for i, input_feat := range input {
-			input[i] = poolLayer.Forward(input_feat)
-		}
+		input = tape.Layer(poolLayer, input)
 	}
 
-	return input
+	return input, skip
 }
 
 // Backward performs a backward pass through the Encoder
-func (enc *Encoder) Backward(gradOutput *mat64.Dense, learningRate float64) {
+// and returns the gradient of the Encoder's input
+func (enc *Encoder) Backward(gradOutput *Tensor) *Tensor {
+	return enc.BackwardWithSkip(gradOutput, nil)
+}
+
+// BackwardWithSkip performs a backward pass through the Encoder where the skip
+// features returned by ForwardWithSkip received gradSkip (which may be nil),
+// and returns the gradient of the Encoder's input
+func (enc *Encoder) BackwardWithSkip(gradOutput *Tensor, gradSkip *Tensor) *Tensor {
+	// Backward pass through pooling layers
+	for i := len(enc.poolLayers) - 1; i >= 0; i-- {
+		gradOutput = enc.poolLayers[i].Backward(gradOutput)
+	}
+	// the skip features branch off here, so their gradient joins the main path
+	if gradSkip != nil {
+		gradOutput = gradOutput.Clone()
+		gradOutput.Add(gradSkip)
+	}
 	// Backward pass through convolutional layers
 	for i := len(enc.convLayers) - 1; i >= 0; i-- {
-		enc.convLayers[i].Backward(gradOutput, learningRate)
+		gradOutput = enc.convLayers[i].Backward(gradOutput)
 	}
+	return gradOutput
+}
+
+// Params returns the parameters of every layer in the Encoder
+func (enc *Encoder) Params() []*Tensor {
+	return append(collectParams(enc.convLayers), collectParams(enc.poolLayers)...)
+}
+
+// Grads returns the gradients of every layer in the Encoder
+func (enc *Encoder) Grads() []*Tensor {
+	return append(collectGrads(enc.convLayers), collectGrads(enc.poolLayers)...)
 }
 
 // Summary returns a summary of the Encoder
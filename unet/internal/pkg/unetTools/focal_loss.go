@@ -0,0 +1,62 @@
+package unetTools
+
+import (
+	"math"
+)
+
+// FocalLoss is the binary focal loss of every element,
+//
+//	-alpha_t * (1 - p_t)^Gamma * log(p_t)
+//
+// with p_t = p and alpha_t = Alpha for a target of 1, p_t = 1 - p and
+// alpha_t = 1 - Alpha for a target of 0. Gamma shrinks the loss of the
+// elements that are already well classified, so the rare hard ones dominate;
+// a Gamma of 0 and an Alpha of 0.5 give half the binary cross-entropy. The
+// targets must be 0 or 1. With FromLogits the prediction holds logits, and
+// the loss is computed from them without clamping.
+type FocalLoss struct {
+	ClassOptions
+	Gamma      float64
+	Alpha      float64
+	FromLogits bool
+}
+
+// NewFocalLoss initializes a new instance of FocalLoss
+func NewFocalLoss(gamma, alpha float64, fromLogits bool, options ClassOptions) FocalLoss {
+	return FocalLoss{ClassOptions: options, Gamma: gamma, Alpha: alpha, FromLogits: fromLogits}
+}
+
+// element returns the loss of one element and its derivative
+func (fl FocalLoss) element(x, t float64) (float64, float64) {
+	alpha := fl.Alpha*t + (1-fl.Alpha)*(1-t)
+	sign := 2*t - 1 // derivative of p_t with respect to p
+	if fl.FromLogits {
+		// p_t = sigmoid(sign * x), so log(p_t) = -log(1 + e^(-sign*x))
+		logPt := -Softplus{}.Forward(-sign * x)
+		pt := math.Exp(logPt)
+		focus := math.Pow(1-pt, fl.Gamma)
+		loss := -alpha * focus * logPt
+		grad := -alpha * sign * focus * ((1 - pt) - fl.Gamma*pt*logPt)
+		return loss, grad
+	}
+	pt, clamped := clampProb(t*x + (1-t)*(1-x))
+	focus := math.Pow(1-pt, fl.Gamma)
+	loss := -alpha * focus * math.Log(pt)
+	if clamped {
+		return loss, 0
+	}
+	grad := -alpha * sign * (focus/pt - fl.Gamma*math.Pow(1-pt, fl.Gamma-1)*math.Log(pt))
+	return loss, grad
+}
+
+// Value returns the focal loss of pred
+func (fl FocalLoss) Value(pred, target *Tensor) float64 {
+	return binaryLoss("FocalLoss", pred, target, fl.ClassOptions, nil, fl.element)
+}
+
+// Grad returns the gradient of the focal loss with respect to pred
+func (fl FocalLoss) Grad(pred, target *Tensor) *Tensor {
+	grad := NewTensorLike(pred)
+	binaryLoss("FocalLoss", pred, target, fl.ClassOptions, grad, fl.element)
+	return grad
+}
@@ -0,0 +1,159 @@
+package unetTools
+
+import (
+	"fmt"
+
+	"github.com/gonum/blas"
+	"github.com/gonum/blas/blas64"
+)
+
+// Convolution backends for ConvParams.Backend
+const (
+	ConvBackendGemm  = "gemm"  // im2col followed by a BLAS matrix multiply
+	ConvBackendNaive = "naive" // one Convolve call per filter and channel, kept as a reference
+)
+
+// checkConvBackend panics if backend is not a known convolution backend
+func checkConvBackend(backend string) {
+	switch backend {
+	case ConvBackendGemm, ConvBackendNaive:
+	default:
+		panic(fmt.Sprintf("unknown convolution backend %q", backend))
+	}
+}
+
+// im2col unrolls sample s of the padded input into a (C*K*K) x (outputRows*outputCols)
+// matrix: row c*K*K + x*K + y holds, for every output position, the input element
+// read by tap (x, y) of the kernel of channel c. A correlation of every channel
+// with its kernel then becomes a single matrix multiply with the filters.
+// The rows are filled in parallel.
+func im2col(padded *Tensor, s, kernelSize, stride, dilation, outputRows, outputCols int) blas64.General {
+	_, c, _, w := padded.Dims()
+	taps := kernelSize * kernelSize
+	positions := outputRows * outputCols
+	cols := blas64.General{
+		Rows:   c * taps,
+		Cols:   positions,
+		Stride: positions,
+		Data:   make([]float64, c*taps*positions),
+	}
+	parallelFor(c*taps, func(r int) {
+		plane := padded.Plane(s, r/taps)
+		x, y := r%taps/kernelSize, r%kernelSize
+		row := cols.Data[r*positions : (r+1)*positions]
+		for i := 0; i < outputRows; i++ {
+			src := plane[(i*stride+x*dilation)*w+y*dilation:]
+			dst := row[i*outputCols : (i+1)*outputCols]
+			for j := range dst {
+				dst[j] = src[j*stride]
+			}
+		}
+	})
+	return cols
+}
+
+// col2im is the adjoint of im2col for channel ch: it adds every element of the
+// rows of cols that belong to ch to the element of sample s of gradPadded it was read from
+func col2im(cols blas64.General, gradPadded *Tensor, s, ch, kernelSize, stride, dilation, outputRows, outputCols int) {
+	_, _, _, w := gradPadded.Dims()
+	positions := outputRows * outputCols
+	plane := gradPadded.Plane(s, ch)
+	for x := 0; x < kernelSize; x++ {
+		for y := 0; y < kernelSize; y++ {
+			row := cols.Data[((ch*kernelSize+x)*kernelSize+y)*positions:]
+			for i := 0; i < outputRows; i++ {
+				dst := plane[(i*stride+x*dilation)*w+y*dilation:]
+				src := row[i*outputCols : (i+1)*outputCols]
+				for j, v := range src {
+					dst[j*stride] += v
+				}
+			}
+		}
+	}
+}
+
+// general returns rows x cols of data, starting at offset, as a BLAS matrix
+func general(data []float64, offset, rows, cols int) blas64.General {
+	return blas64.General{
+		Rows:   rows,
+		Cols:   cols,
+		Stride: cols,
+		Data:   data[offset : offset+rows*cols],
+	}
+}
+
+// forwardGemm writes the pre-activation output of the layer into out.
+// The Weights tensor is already laid out as a NumFilters x (C*K*K) matrix,
+// so for every sample the output is
+//
+//	out_s = 1/(K*K) * W x im2col(input_s) + b
+//
+// computed in place in the contiguous NumFilters x (H*W) block of sample s,
+// one filter (row of W) per unit of parallel work.
+func (cl *ConvLayer) forwardGemm(out *Tensor) {
+	n, c, _, _ := cl._padded.Dims()
+	_, _, outputRows, outputCols := out.Dims()
+	k := cl.KernelSize
+	scale := 1.0 / float64(k*k)
+	for s := 0; s < n; s++ {
+		cols := im2col(cl._padded, s, k, cl.Stride, cl.Dilation, outputRows, outputCols)
+		parallelFor(cl.NumFilters, func(f int) {
+			weights := general(cl.Weights.Data, cl.Weights.Index(f, 0, 0, 0), 1, c*k*k)
+			result := general(out.Data, out.Index(s, f, 0, 0), 1, cols.Cols)
+			blas64.Gemm(blas.NoTrans, blas.NoTrans, scale, weights, cols, 0, result)
+			bias := cl.Biases.At(f, 0, 0, 0)
+			plane := out.Plane(s, f)
+			for j := range plane {
+				plane[j] += bias
+			}
+		})
+	}
+}
+
+// backwardGemm accumulates the parameter gradients and adds the gradient of
+// the padded input to gradPadded. With G_s the pre-activation gradient of
+// sample s as a NumFilters x (H*W) matrix,
+//
+//	gradW += 1/(K*K) * G_s x im2col(input_s)^T
+//	gradB += row sums of G_s
+//	gradInput_s = col2im(1/(K*K) * W^T x G_s)
+//
+// The parameter gradients are split by filter and the input gradient by
+// channel. The columns are rebuilt rather than cached, as they are K*K
+// times larger than the input.
+func (cl *ConvLayer) backwardGemm(gradPreact, gradPadded *Tensor) {
+	n, c, _, _ := cl._padded.Dims()
+	_, _, outputRows, outputCols := gradPreact.Dims()
+	k := cl.KernelSize
+	taps := k * k
+	scale := 1.0 / float64(taps)
+	for s := 0; s < n; s++ {
+		cols := im2col(cl._padded, s, k, cl.Stride, cl.Dilation, outputRows, outputCols)
+		grad := general(gradPreact.Data, gradPreact.Index(s, 0, 0, 0), cl.NumFilters, cols.Cols)
+
+		parallelFor(cl.NumFilters, func(f int) {
+			gradFilter := general(gradPreact.Data, gradPreact.Index(s, f, 0, 0), 1, cols.Cols)
+			gradWeights := general(cl.gradWeights.Data, cl.gradWeights.Index(f, 0, 0, 0), 1, c*taps)
+			blas64.Gemm(blas.NoTrans, blas.Trans, scale, gradFilter, cols, 1, gradWeights)
+			sum := 0.0
+			for _, v := range gradPreact.Plane(s, f) {
+				sum += v
+			}
+			cl.gradBiases.Data[f] += sum
+		})
+
+		gradCols := blas64.General{Rows: cols.Rows, Cols: cols.Cols, Stride: cols.Stride, Data: make([]float64, len(cols.Data))}
+		parallelFor(c, func(ch int) {
+			// the K*K columns of W that belong to channel ch
+			weights := blas64.General{
+				Rows:   cl.NumFilters,
+				Cols:   taps,
+				Stride: c * taps,
+				Data:   cl.Weights.Data[ch*taps:],
+			}
+			gradChannel := general(gradCols.Data, ch*taps*cols.Cols, taps, cols.Cols)
+			blas64.Gemm(blas.Trans, blas.NoTrans, scale, weights, grad, 0, gradChannel)
+			col2im(gradCols, gradPadded, s, ch, k, cl.Stride, cl.Dilation, outputRows, outputCols)
+		})
+	}
+}
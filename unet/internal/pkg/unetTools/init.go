@@ -0,0 +1,110 @@
+package unetTools
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Initializers for ConvParams.WeightInit/BiasInit and ConvTransParams.WeightInit/BiasInit
+const (
+	InitUniform       = "uniform"        // uniform in [0, 1), the original initialization
+	InitHeNormal      = "he_normal"      // normal with variance 2 / fanIn, for relu layers
+	InitHeUniform     = "he_uniform"     // uniform with variance 2 / fanIn
+	InitXavierNormal  = "xavier_normal"  // normal with variance 2 / (fanIn + fanOut), for sigmoid layers
+	InitXavierUniform = "xavier_uniform" // uniform with variance 2 / (fanIn + fanOut)
+	InitOrthogonal    = "orthogonal"     // the filters, flattened, are orthonormal
+	InitZeros         = "zeros"          // all zeros, for biases
+)
+
+// checkInit panics if init is not a known initializer
+func checkInit(init string) {
+	switch init {
+	case "", InitUniform, InitHeNormal, InitHeUniform, InitXavierNormal, InitXavierUniform, InitOrthogonal, InitZeros:
+	default:
+		panic(fmt.Sprintf("unknown initializer %q", init))
+	}
+}
+
+// initTensor fills t, seen as a matrix with one row per filter, using the
+// named initializer and drawing from rng. fanIn and fanOut are the number of
+// inputs and outputs of every unit. "" means InitUniform.
+func initTensor(t *Tensor, init string, fanIn, fanOut int, rng *rand.Rand) {
+	checkInit(init)
+	switch init {
+	case "", InitUniform:
+		for i := range t.Data {
+			t.Data[i] = rng.Float64()
+		}
+	case InitHeNormal:
+		fillNormal(t.Data, math.Sqrt(2/float64(fanIn)), rng)
+	case InitHeUniform:
+		fillUniform(t.Data, math.Sqrt(6/float64(fanIn)), rng)
+	case InitXavierNormal:
+		fillNormal(t.Data, math.Sqrt(2/float64(fanIn+fanOut)), rng)
+	case InitXavierUniform:
+		fillUniform(t.Data, math.Sqrt(6/float64(fanIn+fanOut)), rng)
+	case InitOrthogonal:
+		fillOrthogonal(t, rng)
+	case InitZeros:
+		t.Zero()
+	}
+}
+
+// fillNormal fills values from a normal distribution with mean 0 and deviation std
+func fillNormal(values []float64, std float64, rng *rand.Rand) {
+	for i := range values {
+		values[i] = rng.NormFloat64() * std
+	}
+}
+
+// fillUniform fills values from a uniform distribution on [-limit, limit)
+func fillUniform(values []float64, limit float64, rng *rand.Rand) {
+	for i := range values {
+		values[i] = (2*rng.Float64() - 1) * limit
+	}
+}
+
+// fillOrthogonal fills t so that its filters, flattened into the rows of an
+// N x (C*H*W) matrix, are orthonormal (or its columns are, if there are more
+// rows than columns). The matrix is the Q factor of a Gaussian matrix with
+// the signs fixed by R, so it is uniformly distributed.
+func fillOrthogonal(t *Tensor, rng *rand.Rand) {
+	rows := t.Shape[0]
+	cols := t.Len() / rows
+	big, small := rows, cols
+	if big < small {
+		big, small = small, big
+	}
+	a := mat64.NewDense(big, small, nil)
+	fillNormal(a.RawMatrix().Data, 1, rng)
+
+	var qr mat64.QR
+	qr.Factorize(a)
+	var q, r mat64.Dense
+	q.QFromQR(&qr)
+	r.RFromQR(&qr)
+	for i := 0; i < big; i++ {
+		for j := 0; j < small; j++ {
+			v := q.At(i, j)
+			if r.At(j, j) < 0 {
+				v = -v
+			}
+			if rows >= cols {
+				t.Data[i*cols+j] = v
+			} else {
+				t.Data[j*cols+i] = v
+			}
+		}
+	}
+}
+
+// randOrGlobal returns rng, or a generator seeded from the global source if rng is nil
+func randOrGlobal(rng *rand.Rand) *rand.Rand {
+	if rng == nil {
+		return rand.New(rand.NewSource(rand.Int63()))
+	}
+	return rng
+}
@@ -0,0 +1,46 @@
+package unetTools
+
+// Layer is the common interface implemented by every layer of the network.
+//
+// Forward caches whatever it needs for the backward pass, so a layer must see
+// exactly one Forward call before each Backward call. Backward accumulates the
+// gradients of the layer's parameters into the tensors returned by Grads and
+// returns the gradient of the loss with respect to the layer's input.
+// Params and Grads return tensors in matching order; layers without
+// parameters return nil from both. Layers never update their own parameters,
+// an Optimizer does that from the gradients.
+type Layer interface {
+	Forward(input *Tensor) *Tensor
+	Backward(gradOutput *Tensor) *Tensor
+	Params() []*Tensor
+	Grads() []*Tensor
+	Summary() string
+}
+
+// collectParams returns the parameters of all layers, in order
+func collectParams(layers []Layer) []*Tensor {
+	var params []*Tensor
+	for _, layer := range layers {
+		params = append(params, layer.Params()...)
+	}
+	return params
+}
+
+// collectGrads returns the gradients of all layers, in the same order as collectParams
+func collectGrads(layers []Layer) []*Tensor {
+	var grads []*Tensor
+	for _, layer := range layers {
+		grads = append(grads, layer.Grads()...)
+	}
+	return grads
+}
+
+// every layer in the package implements Layer
+var (
+	_ Layer = (*ConvLayer)(nil)
+	_ Layer = (*ConvTransLayer)(nil)
+	_ Layer = (*MaxPoolLayer)(nil)
+	_ Layer = (*UpsampleLayer)(nil)
+	_ Layer = (*SoftmaxLayer)(nil)
+	_ Layer = (*Encoder)(nil)
+)
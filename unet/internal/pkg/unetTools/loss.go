@@ -0,0 +1,51 @@
+package unetTools
+
+import (
+	"fmt"
+)
+
+// Reductions of the per-sample losses of a batch
+const (
+	LossReductionMean = "mean" // average over the batch, so gradients are averaged too
+	LossReductionSum  = "sum"  // sum over the batch
+	LossReductionNone = "none" // keep one loss per sample
+)
+
+// checkLossReduction panics if reduction is not a known loss reduction
+func checkLossReduction(reduction string) {
+	switch reduction {
+	case LossReductionMean, LossReductionSum, LossReductionNone:
+	default:
+		panic(fmt.Sprintf("unknown loss reduction %q", reduction))
+	}
+}
+
+// Loss is a loss function comparing the prediction for one sample with its
+// target. Both are 1 x C x H x W Tensors of the same shape. Grad returns the
+// gradient of Value with respect to pred, as a new Tensor.
+type Loss interface {
+	Value(pred, target *Tensor) float64
+	Grad(pred, target *Tensor) *Tensor
+}
+
+// StepObserver is implemented by losses that change during training, such as
+// CompositeLoss. Unet.Loss reports the number of optimizer steps taken to its
+// loss if it implements StepObserver.
+type StepObserver interface {
+	ObserveStep(step int)
+}
+
+// every loss in the package implements Loss
+var (
+	_ Loss = MSE{}
+	_ Loss = Dice{}
+	_ Loss = GeneralizedDice{}
+	_ Loss = Tversky{}
+	_ Loss = FocalTversky{}
+	_ Loss = BinaryCrossEntropy{}
+	_ Loss = CategoricalCrossEntropy{}
+	_ Loss = FocalLoss{}
+	_ Loss = (*CompositeLoss)(nil)
+
+	_ StepObserver = (*CompositeLoss)(nil)
+)
@@ -3,8 +3,6 @@ package unetTools
 import (
 	"fmt"
 	"math"
-
-	"github.com/gonum/matrix/mat64"
 )
 
 // MaxPoolParams represents the parameters for a max pooling layer
@@ -17,6 +15,8 @@ type PoolParams struct {
 type MaxPoolLayer struct {
 	poolSize int
 	stride   int
+	_input   *Tensor
+	_argmax  []int // index into _input.Data of the maximum of each pooling window
 }
 
 // NewMaxPoolLayer initializes a new instance of MaxPoolLayer
@@ -27,35 +27,67 @@ func NewMaxPoolLayer(poolSize, stride int) *MaxPoolLayer {
 	}
 }
 
-func (mpl *MaxPoolLayer) Forward(input *mat64.Dense) *mat64.Dense {
-	inputRows, inputCols := input.Dims()
+// Forward performs a forward pass through the MaxPoolLayer,
+// pooling every channel of every sample independently.
+// The position of the maximum of every window is recorded for Backward.
+func (mpl *MaxPoolLayer) Forward(input *Tensor) *Tensor {
+	numSamples, numChannels, inputRows, inputCols := input.Dims()
 	outputRows := (inputRows-mpl.poolSize)/mpl.stride + 1
 	outputCols := (inputCols-mpl.poolSize)/mpl.stride + 1
-	output := mat64.NewDense(outputRows, outputCols, nil)
+	output := NewTensor(numSamples, numChannels, outputRows, outputCols)
+	mpl._input = input
+	mpl._argmax = make([]int, output.Len())
 
-	for i := 0; i < outputRows; i++ {
-		for j := 0; j < outputCols; j++ {
-			maxVal := math.Inf(-1) // initialize with negative infinity
-			for m := 0; m < mpl.poolSize; m++ {
-				for n := 0; n < mpl.poolSize; n++ {
-					val := input.At(i*mpl.stride+m, j*mpl.stride+n)
-					if val > maxVal {
-						maxVal = val
+	for s := 0; s < numSamples; s++ {
+		for c := 0; c < numChannels; c++ {
+			for i := 0; i < outputRows; i++ {
+				for j := 0; j < outputCols; j++ {
+					maxVal := math.Inf(-1) // initialize with negative infinity
+					maxIdx := input.Index(s, c, i*mpl.stride, j*mpl.stride)
+					for m := 0; m < mpl.poolSize; m++ {
+						for n := 0; n < mpl.poolSize; n++ {
+							idx := input.Index(s, c, i*mpl.stride+m, j*mpl.stride+n)
+							if input.Data[idx] > maxVal {
+								maxVal = input.Data[idx]
+								maxIdx = idx
+							}
+						}
 					}
+					out := output.Index(s, c, i, j)
+					output.Data[out] = maxVal
+					mpl._argmax[out] = maxIdx
 				}
 			}
-			output.Set(i, j, maxVal)
 		}
 	}
 
 	return output
 }
 
-// Backward performs a backward pass through the MaxPoolLayer
-func (mpl *MaxPoolLayer) Backward(gradInput *mat64.Dense) *mat64.Dense {
+// Backward performs a backward pass through the MaxPoolLayer.
+// The gradient of every output element is routed to the input element that
+// won its pooling window; where windows overlap the gradients add up.
+func (mpl *MaxPoolLayer) Backward(gradOutput *Tensor) *Tensor {
+	if gradOutput.Len() != len(mpl._argmax) {
+		panic(fmt.Sprintf("MaxPoolLayer.Backward: gradient %v does not match the last output", gradOutput.Shape))
+	}
+	gradInput := NewTensorLike(mpl._input)
+	for out, idx := range mpl._argmax {
+		gradInput.Data[idx] += gradOutput.Data[out]
+	}
 	return gradInput
 }
 
+// Params returns nil since the MaxPoolLayer has no parameters
+func (mpl *MaxPoolLayer) Params() []*Tensor {
+	return nil
+}
+
+// Grads returns nil since the MaxPoolLayer has no parameters
+func (mpl *MaxPoolLayer) Grads() []*Tensor {
+	return nil
+}
+
 // Summary returns a string representation of the MaxPoolLayer
 func (mpl *MaxPoolLayer) Summary() string {
 	ret := "	MaxPoolLayer\n"
@@ -6,6 +6,29 @@ import (
 	"github.com/gonum/matrix/mat64"
 )
 
+// MSE is the mean squared error over every element of the prediction
+type MSE struct{}
+
+// Value returns the mean of (pred - target)^2
+func (MSE) Value(pred, target *Tensor) float64 {
+	mustSameShape("MSE", pred, target)
+	sum := 0.0
+	for i, p := range pred.Data {
+		sum += (p - target.Data[i]) * (p - target.Data[i])
+	}
+	return sum / float64(pred.Len())
+}
+
+// Grad returns 2 * (pred - target) / n, with n the number of elements
+func (MSE) Grad(pred, target *Tensor) *Tensor {
+	mustSameShape("MSE", pred, target)
+	grad := pred.Clone()
+	grad.Sub(target)
+	grad.Scale(2 / float64(pred.Len()))
+	return grad
+}
+
+// MeanSquaredErr calculates the mean squared error between two matrices
 func MeanSquaredErr(prediction *mat64.Dense, target *mat64.Dense) float64 {
 	// Convert prediction and target masks to slices
 	pred := prediction.RawMatrix().Data
@@ -23,10 +46,13 @@ func MeanSquaredErr(prediction *mat64.Dense, target *mat64.Dense) float64 {
 	return mse
 }
 
+// MeanSquaredErrGradient calculates the gradient of MeanSquaredErr with respect to prediction
 func MeanSquaredErrGradient(prediction *mat64.Dense, target *mat64.Dense) *mat64.Dense {
 	// Compute the gradient of the mean squared error
 	gradient := mat64.NewDense(target.RawMatrix().Rows, prediction.RawMatrix().Cols, nil)
 	gradient.Sub(prediction, target)
+	rows, cols := gradient.Dims()
+	gradient.Scale(2/float64(rows*cols), gradient)
 
 	return gradient
 }
@@ -6,7 +6,6 @@ import (
 	"image/color"
 	"image/jpeg"
 	"math"
-	"math/rand"
 	"os"
 	"slices"
 
@@ -14,37 +13,6 @@ import (
 	mat "github.com/gonum/matrix/mat64"
 )
 
-// Apply Activation function to the input matrix
-func applyActivation(matrix *mat64.Dense, activation string) {
-	numRows, numCols := matrix.Dims()
-	switch activation {
-	case "relu":
-		for i := 0; i < numRows; i++ {
-			for j := 0; j < numCols; j++ {
-				if matrix.At(i, j) < 0.5 {
-					matrix.Set(i, j, 0)
-				}
-			}
-		}
-	case "sigmoid":
-		for i := 0; i < numRows; i++ {
-			for j := 0; j < numCols; j++ {
-				matrix.Set(i, j, 1/(1+math.Exp(-matrix.At(i, j))))
-			}
-		}
-		// Add more activation functions as needed
-	}
-}
-
-// randomMatrixValues generates random values for a matrix of the specified size
-func randomMatrixValues(size int) []float64 {
-	values := make([]float64, size)
-	for i := range values {
-		values[i] = rand.Float64()
-	}
-	return values
-}
-
 // MatrixSqrt computes the square root of each element in the input matrix
 func MatrixSqrt(matrix *mat64.Dense) *mat64.Dense {
 	numRows, numCols := matrix.Dims()
@@ -241,6 +209,50 @@ func ResizeMatrix(input *mat.Dense, newRows, newCols int) *mat.Dense {
 	return output
 }
 
+// ResizeMatrixBackward computes the gradient of ResizeMatrix with respect to its
+// inputRows x inputCols input, given the gradient of its output.
+// Each output gradient is scattered back to the four input elements
+// it was interpolated from, with the same bilinear weights.
+func ResizeMatrixBackward(gradOutput *mat.Dense, inputRows, inputCols int) *mat.Dense {
+	newRows, newCols := gradOutput.Dims()
+	gradInput := mat.NewDense(inputRows, inputCols, nil)
+
+	// Calculate the scaling factors
+	scaleRow := float64(inputRows-1) / float64(newRows-1)
+	scaleCol := float64(inputCols-1) / float64(newCols-1)
+
+	for i := 0; i < newRows; i++ {
+		for j := 0; j < newCols; j++ {
+			// Calculate the corresponding coordinates in the input matrix
+			x := float64(i) * scaleRow
+			y := float64(j) * scaleCol
+
+			// Find the four nearest neighbors in the input matrix
+			x1 := int(x)
+			y1 := int(y)
+			x2 := x1 + 1
+			y2 := y1 + 1
+			if x2 >= inputRows {
+				x2 = x1
+			}
+			if y2 >= inputCols {
+				y2 = y1
+			}
+
+			// Scatter the gradient with the bilinear weights
+			dx := x - float64(x1)
+			dy := y - float64(y1)
+			g := gradOutput.At(i, j)
+			gradInput.Set(x1, y1, gradInput.At(x1, y1)+g*(1-dx)*(1-dy))
+			gradInput.Set(x1, y2, gradInput.At(x1, y2)+g*(1-dx)*dy)
+			gradInput.Set(x2, y1, gradInput.At(x2, y1)+g*dx*(1-dy))
+			gradInput.Set(x2, y2, gradInput.At(x2, y2)+g*dx*dy)
+		}
+	}
+
+	return gradInput
+}
+
 // ConcatenateHorizontally concatenates two matrices horizontally
 func ConcatenateHorizontally(matrix1, matrix2 *mat.Dense) *mat.Dense {
 	// Get the dimensions of the input matrices
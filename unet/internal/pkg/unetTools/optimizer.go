@@ -0,0 +1,191 @@
+package unetTools
+
+import (
+	"fmt"
+	"math"
+)
+
+// Optimizer updates parameters from their gradients.
+//
+// An Optimizer owns whatever state it keeps for every parameter (momentum,
+// moment estimates, ...), keyed by the parameter Tensor, so the same
+// parameters must be passed to every Update. Update is called once per
+// optimizer step with the parameters and gradients in matching order, as
+// returned by Params and Grads. It does not reset the gradients.
+type Optimizer interface {
+	Update(params, grads []*Tensor, learningRate float64)
+}
+
+// checkUpdate panics if params and grads do not match
+func checkUpdate(params, grads []*Tensor) {
+	if len(params) != len(grads) {
+		panic(fmt.Sprintf("optimizer got %d parameters and %d gradients", len(params), len(grads)))
+	}
+	for i := range params {
+		mustSameShape("Optimizer.Update", params[i], grads[i])
+	}
+}
+
+// stateFor returns the buffer kept in state for param, creating a zero
+// buffer the first time param is seen
+func stateFor(state map[*Tensor][]float64, param *Tensor) []float64 {
+	buffer, ok := state[param]
+	if !ok {
+		buffer = make([]float64, param.Len())
+		state[param] = buffer
+	}
+	return buffer
+}
+
+// SGD is plain stochastic gradient descent: p -= lr * g
+type SGD struct{}
+
+// NewSGD initializes a new instance of SGD
+func NewSGD() *SGD {
+	return &SGD{}
+}
+
+// Update applies one SGD step to params
+func (opt *SGD) Update(params, grads []*Tensor, learningRate float64) {
+	checkUpdate(params, grads)
+	for i, param := range params {
+		for j, g := range grads[i].Data {
+			param.Data[j] -= learningRate * g
+		}
+	}
+}
+
+// Nesterov is stochastic gradient descent with Nesterov momentum:
+//
+//	v = Momentum * v + g
+//	p -= lr * (g + Momentum * v)
+type Nesterov struct {
+	Momentum float64
+
+	velocity map[*Tensor][]float64
+}
+
+// NewNesterov initializes a new instance of Nesterov
+func NewNesterov(momentum float64) *Nesterov {
+	return &Nesterov{
+		Momentum: momentum,
+		velocity: make(map[*Tensor][]float64),
+	}
+}
+
+// Update applies one Nesterov momentum step to params
+func (opt *Nesterov) Update(params, grads []*Tensor, learningRate float64) {
+	checkUpdate(params, grads)
+	for i, param := range params {
+		v := stateFor(opt.velocity, param)
+		for j, g := range grads[i].Data {
+			v[j] = opt.Momentum*v[j] + g
+			param.Data[j] -= learningRate * (g + opt.Momentum*v[j])
+		}
+	}
+}
+
+// RMSProp scales every step by a running average of the squared gradients:
+//
+//	s = Rho * s + (1 - Rho) * g^2
+//	p -= lr * g / (sqrt(s) + Epsilon)
+type RMSProp struct {
+	Rho     float64
+	Epsilon float64
+
+	square map[*Tensor][]float64
+}
+
+// NewRMSProp initializes a new instance of RMSProp
+func NewRMSProp(rho, epsilon float64) *RMSProp {
+	return &RMSProp{
+		Rho:     rho,
+		Epsilon: epsilon,
+		square:  make(map[*Tensor][]float64),
+	}
+}
+
+// Update applies one RMSProp step to params
+func (opt *RMSProp) Update(params, grads []*Tensor, learningRate float64) {
+	checkUpdate(params, grads)
+	for i, param := range params {
+		s := stateFor(opt.square, param)
+		for j, g := range grads[i].Data {
+			s[j] = opt.Rho*s[j] + (1-opt.Rho)*g*g
+			param.Data[j] -= learningRate * g / (math.Sqrt(s[j]) + opt.Epsilon)
+		}
+	}
+}
+
+// Adam keeps bias-corrected running averages of the gradients and of their
+// squares. With a WeightDecay it is AdamW: the decay is decoupled from the
+// gradients and shrinks the parameters directly, before the Adam step:
+//
+//	p -= lr * WeightDecay * p
+//	m = Beta1 * m + (1 - Beta1) * g
+//	v = Beta2 * v + (1 - Beta2) * g^2
+//	p -= lr * (m / (1 - Beta1^t)) / (sqrt(v / (1 - Beta2^t)) + Epsilon)
+type Adam struct {
+	Beta1       float64
+	Beta2       float64
+	Epsilon     float64
+	WeightDecay float64
+
+	m map[*Tensor][]float64 // first moment of every parameter
+	v map[*Tensor][]float64 // second moment of every parameter
+	t int                   // optimizer steps taken
+}
+
+// NewAdam initializes a new instance of Adam
+func NewAdam(beta1, beta2, epsilon float64) *Adam {
+	return NewAdamW(beta1, beta2, epsilon, 0)
+}
+
+// NewAdamW initializes a new instance of Adam with decoupled weight decay
+func NewAdamW(beta1, beta2, epsilon, weightDecay float64) *Adam {
+	return &Adam{
+		Beta1:       beta1,
+		Beta2:       beta2,
+		Epsilon:     epsilon,
+		WeightDecay: weightDecay,
+		m:           make(map[*Tensor][]float64),
+		v:           make(map[*Tensor][]float64),
+	}
+}
+
+// Steps returns the number of optimizer steps taken so far. All parameters
+// share this counter for their bias correction, whichever layer they belong to.
+func (opt *Adam) Steps() int {
+	return opt.t
+}
+
+// Update applies one Adam step to params. Every parameter Tensor has its
+// own moment buffers, and the step counter advances once per call.
+func (opt *Adam) Update(params, grads []*Tensor, learningRate float64) {
+	checkUpdate(params, grads)
+	opt.t++
+	correction1 := 1 - math.Pow(opt.Beta1, float64(opt.t))
+	correction2 := 1 - math.Pow(opt.Beta2, float64(opt.t))
+	for i, param := range params {
+		m := stateFor(opt.m, param)
+		v := stateFor(opt.v, param)
+		for j, g := range grads[i].Data {
+			if opt.WeightDecay != 0 {
+				param.Data[j] -= learningRate * opt.WeightDecay * param.Data[j]
+			}
+			m[j] = opt.Beta1*m[j] + (1-opt.Beta1)*g
+			v[j] = opt.Beta2*v[j] + (1-opt.Beta2)*g*g
+			mHat := m[j] / correction1
+			vHat := v[j] / correction2
+			param.Data[j] -= learningRate * mHat / (math.Sqrt(vHat) + opt.Epsilon)
+		}
+	}
+}
+
+// every optimizer in the package implements Optimizer
+var (
+	_ Optimizer = (*SGD)(nil)
+	_ Optimizer = (*Nesterov)(nil)
+	_ Optimizer = (*RMSProp)(nil)
+	_ Optimizer = (*Adam)(nil)
+)
@@ -0,0 +1,126 @@
+package unetTools
+
+import (
+	"fmt"
+)
+
+// Padding modes for ConvParams.Padding
+const (
+	PaddingValid     = "valid"     // no padding, every convolution shrinks the map
+	PaddingSame      = "same"      // zero padding, the output keeps the size of the input
+	PaddingReflect   = "reflect"   // like "same", but mirrors the input at its border (edge excluded)
+	PaddingReplicate = "replicate" // like "same", but repeats the edge of the input
+)
+
+// checkPadding panics if padding is not a known padding mode
+func checkPadding(padding string) {
+	switch padding {
+	case "", PaddingValid, PaddingSame, PaddingReflect, PaddingReplicate:
+	default:
+		panic(fmt.Sprintf("unknown padding mode %q", padding))
+	}
+}
+
+// padAmounts returns how many rows (or columns) are added before and after
+// an axis of length size so that a kernel spanning span elements, moved by
+// stride, yields ceil(size/stride) outputs (size outputs for a stride of 1).
+// Odd amounts put the extra element after, matching the usual "same" convention.
+func padAmounts(padding string, size, span, stride int) (before, after int) {
+	if padding == "" || padding == PaddingValid {
+		return 0, 0
+	}
+	outputs := (size + stride - 1) / stride
+	total := (outputs-1)*stride + span - size
+	if total < 0 {
+		total = 0
+	}
+	return total / 2, total - total/2
+}
+
+// padSource maps index i of a padded axis of length n (before excluded) to the
+// index of the input element it copies, or -1 if it is a zero.
+func padSource(padding string, i, n int) int {
+	if i >= 0 && i < n {
+		return i
+	}
+	switch padding {
+	case PaddingReflect:
+		for i < 0 || i >= n {
+			if n == 1 {
+				return 0
+			}
+			if i < 0 {
+				i = -i
+			}
+			if i >= n {
+				i = 2*(n-1) - i
+			}
+		}
+		return i
+	case PaddingReplicate:
+		if i < 0 {
+			return 0
+		}
+		return n - 1
+	}
+	return -1
+}
+
+// padTensor pads every channel of input with the given amounts and padding mode
+func padTensor(input *Tensor, padding string, top, bottom, left, right int) *Tensor {
+	if top == 0 && bottom == 0 && left == 0 && right == 0 {
+		return input
+	}
+	n, c, h, w := input.Dims()
+	padded := NewTensor(n, c, h+top+bottom, w+left+right)
+	for s := 0; s < n; s++ {
+		for ch := 0; ch < c; ch++ {
+			src := input.Plane(s, ch)
+			dst := padded.Plane(s, ch)
+			for i := 0; i < h+top+bottom; i++ {
+				si := padSource(padding, i-top, h)
+				if si < 0 {
+					continue
+				}
+				for j := 0; j < w+left+right; j++ {
+					sj := padSource(padding, j-left, w)
+					if sj < 0 {
+						continue
+					}
+					dst[i*(w+left+right)+j] = src[si*w+sj]
+				}
+			}
+		}
+	}
+	return padded
+}
+
+// unpadTensorGrad folds the gradient of a tensor padded by padTensor back onto
+// the h x w input: every padded element adds its gradient to the element it copied
+func unpadTensorGrad(gradPadded *Tensor, padding string, top, left, h, w int) *Tensor {
+	n, c, ph, pw := gradPadded.Dims()
+	if ph == h && pw == w {
+		return gradPadded
+	}
+	gradInput := NewTensor(n, c, h, w)
+	for s := 0; s < n; s++ {
+		for ch := 0; ch < c; ch++ {
+			src := gradPadded.Plane(s, ch)
+			dst := gradInput.Plane(s, ch)
+			for i := 0; i < ph; i++ {
+				si := padSource(padding, i-top, h)
+				if si < 0 {
+					continue
+				}
+				for j := 0; j < pw; j++ {
+					sj := padSource(padding, j-left, w)
+					if sj < 0 {
+						continue
+					}
+					dst[si*w+sj] += src[i*pw+j]
+				}
+			}
+		}
+	}
+	return gradInput
+}
@@ -0,0 +1,64 @@
+package unetTools
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// numWorkers is the number of goroutines parallelFor runs, 0 means GOMAXPROCS
+var numWorkers atomic.Int64
+
+// SetNumWorkers sets the number of goroutines the layers use to split their
+// work across filters, channels and rows. n <= 0 restores the default of
+// runtime.GOMAXPROCS(0). Results do not depend on the number of workers.
+func SetNumWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	numWorkers.Store(int64(n))
+}
+
+// NumWorkers returns the number of goroutines the layers use
+func NumWorkers() int {
+	if n := int(numWorkers.Load()); n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallelFor calls fn(i) for every i in [0, n), spreading the calls over
+// NumWorkers goroutines, and returns once every call has finished.
+//
+// Every i is a fixed unit of work, so as long as fn(i) only writes memory
+// that no other unit touches, the result is the same whatever the number
+// of workers and whichever worker runs a unit.
+func parallelFor(n int, fn func(i int)) {
+	workers := NumWorkers()
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package unetTools
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// Random streams of a Unet, see Unet.Rand
+const (
+	RandInit    = "init"    // initial parameters
+	RandShuffle = "shuffle" // order of the training samples
+	RandAugment = "augment" // data augmentation
+	RandDropout = "dropout" // dropout masks
+)
+
+// streamSeed derives the seed of a named random stream from the seed of a
+// model, so that every stream is independent of how much the others are used
+func streamSeed(seed int64, stream string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	return seed ^ int64(h.Sum64())
+}
+
+// Rand returns the random source of the named stream (one of the Rand*
+// constants, or any other name). Every call with the same name returns the
+// same source, so successive draws continue the stream. In deterministic
+// mode every stream is seeded from the model's seed; otherwise the seeds are
+// drawn from math/rand.
+func (unet *Unet) Rand(stream string) *rand.Rand {
+	if rng, ok := unet.streams[stream]; ok {
+		return rng
+	}
+	if unet.streams == nil {
+		unet.streams = make(map[string]*rand.Rand)
+	}
+	seed := rand.Int63()
+	if unet.deterministic {
+		seed = streamSeed(unet.seed, stream)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	unet.streams[stream] = rng
+	return rng
+}
+
+// Shuffle returns a random permutation of the n sample indices [0, n),
+// drawn from the RandShuffle stream
+func (unet *Unet) Shuffle(n int) []int {
+	return unet.Rand(RandShuffle).Perm(n)
+}
@@ -0,0 +1,204 @@
+package unetTools
+
+import (
+	"math"
+)
+
+// Scheduler computes the learning rate of every optimizer step.
+//
+// LearningRate receives the number of optimizer steps already taken and the
+// base learning rate, and returns the learning rate of the next step.
+type Scheduler interface {
+	LearningRate(step int, base float64) float64
+}
+
+// LossObserver is implemented by schedulers that adapt to the validation
+// loss, such as ReduceOnPlateau. Unet.Validate reports every validation loss
+// to its scheduler if it implements LossObserver.
+type LossObserver interface {
+	Observe(validationLoss float64)
+}
+
+// StepDecay multiplies the learning rate by Gamma every StepSize steps
+type StepDecay struct {
+	StepSize int
+	Gamma    float64
+}
+
+// NewStepDecay initializes a new instance of StepDecay
+func NewStepDecay(stepSize int, gamma float64) *StepDecay {
+	if stepSize <= 0 {
+		panic("StepDecay step size must be positive")
+	}
+	return &StepDecay{StepSize: stepSize, Gamma: gamma}
+}
+
+// LearningRate returns base * Gamma^(step / StepSize)
+func (s *StepDecay) LearningRate(step int, base float64) float64 {
+	return base * math.Pow(s.Gamma, float64(step/s.StepSize))
+}
+
+// ExponentialDecay multiplies the learning rate by Gamma every step
+type ExponentialDecay struct {
+	Gamma float64
+}
+
+// NewExponentialDecay initializes a new instance of ExponentialDecay
+func NewExponentialDecay(gamma float64) *ExponentialDecay {
+	return &ExponentialDecay{Gamma: gamma}
+}
+
+// LearningRate returns base * Gamma^step
+func (s *ExponentialDecay) LearningRate(step int, base float64) float64 {
+	return base * math.Pow(s.Gamma, float64(step))
+}
+
+// CosineWarmRestarts anneals the learning rate from base down to MinLR along
+// a half cosine, then restarts at base. The first cycle lasts Period steps
+// and every following cycle is Mult times longer than the previous one.
+type CosineWarmRestarts struct {
+	Period int
+	Mult   int
+	MinLR  float64
+}
+
+// NewCosineWarmRestarts initializes a new instance of CosineWarmRestarts
+func NewCosineWarmRestarts(period, mult int, minLR float64) *CosineWarmRestarts {
+	if period <= 0 || mult <= 0 {
+		panic("CosineWarmRestarts period and mult must be positive")
+	}
+	return &CosineWarmRestarts{Period: period, Mult: mult, MinLR: minLR}
+}
+
+// LearningRate returns the annealed learning rate within the current cycle
+func (s *CosineWarmRestarts) LearningRate(step int, base float64) float64 {
+	period := s.Period
+	for step >= period {
+		step -= period
+		period *= s.Mult
+	}
+	return s.MinLR + (base-s.MinLR)*(1+math.Cos(math.Pi*float64(step)/float64(period)))/2
+}
+
+// LinearWarmup ramps the learning rate linearly up to base over the first
+// Steps steps, then hands over to After, whose steps start counting at 0.
+// A nil After keeps the learning rate at base.
+type LinearWarmup struct {
+	Steps int
+	After Scheduler
+}
+
+// NewLinearWarmup initializes a new instance of LinearWarmup
+func NewLinearWarmup(steps int, after Scheduler) *LinearWarmup {
+	return &LinearWarmup{Steps: steps, After: after}
+}
+
+// LearningRate returns base * (step+1) / Steps during the warmup
+func (s *LinearWarmup) LearningRate(step int, base float64) float64 {
+	if step < s.Steps {
+		return base * float64(step+1) / float64(s.Steps)
+	}
+	if s.After == nil {
+		return base
+	}
+	return s.After.LearningRate(step-s.Steps, base)
+}
+
+// OneCycle implements the one-cycle policy over TotalSteps steps, with base
+// as the maximum learning rate. The learning rate rises from base / DivFactor
+// to base over the first PctStart of the steps, then anneals down to
+// base / (DivFactor * FinalDivFactor), both along half cosines.
+type OneCycle struct {
+	TotalSteps     int
+	PctStart       float64
+	DivFactor      float64
+	FinalDivFactor float64
+}
+
+// NewOneCycle initializes a new instance of OneCycle
+func NewOneCycle(totalSteps int, pctStart, divFactor, finalDivFactor float64) *OneCycle {
+	if totalSteps <= 1 || pctStart <= 0 || pctStart >= 1 {
+		panic("OneCycle needs more than one step and a PctStart in (0, 1)")
+	}
+	return &OneCycle{
+		TotalSteps:     totalSteps,
+		PctStart:       pctStart,
+		DivFactor:      divFactor,
+		FinalDivFactor: finalDivFactor,
+	}
+}
+
+// LearningRate returns the learning rate of the cycle at step.
+// Steps past TotalSteps keep the final learning rate.
+func (s *OneCycle) LearningRate(step int, base float64) float64 {
+	initial := base / s.DivFactor
+	final := initial / s.FinalDivFactor
+	// cosine interpolation from start to end as pct goes from 0 to 1
+	anneal := func(start, end, pct float64) float64 {
+		return end + (start-end)*(1+math.Cos(math.Pi*pct))/2
+	}
+
+	last := float64(s.TotalSteps - 1)
+	peak := math.Max(1, math.Round(s.PctStart*last))
+	t := math.Min(float64(step), last)
+	if t <= peak {
+		return anneal(initial, base, t/peak)
+	}
+	return anneal(base, final, (t-peak)/(last-peak))
+}
+
+// ReduceOnPlateau multiplies the learning rate by Factor whenever the
+// validation loss has not improved by more than Threshold for more than
+// Patience observations in a row. The learning rate never drops below MinLR.
+type ReduceOnPlateau struct {
+	Factor    float64
+	Patience  int
+	Threshold float64
+	MinLR     float64
+
+	scale float64 // product of all reductions so far
+	best  float64 // best validation loss so far
+	bad   int     // observations since the last improvement
+}
+
+// NewReduceOnPlateau initializes a new instance of ReduceOnPlateau
+func NewReduceOnPlateau(factor float64, patience int, threshold, minLR float64) *ReduceOnPlateau {
+	return &ReduceOnPlateau{
+		Factor:    factor,
+		Patience:  patience,
+		Threshold: threshold,
+		MinLR:     minLR,
+		scale:     1,
+		best:      math.Inf(1),
+	}
+}
+
+// Observe records a validation loss and reduces the learning rate on a plateau
+func (s *ReduceOnPlateau) Observe(validationLoss float64) {
+	if validationLoss < s.best-s.Threshold {
+		s.best = validationLoss
+		s.bad = 0
+		return
+	}
+	s.bad++
+	if s.bad > s.Patience {
+		s.scale *= s.Factor
+		s.bad = 0
+	}
+}
+
+// LearningRate returns base scaled by every reduction so far
+func (s *ReduceOnPlateau) LearningRate(_ int, base float64) float64 {
+	return math.Max(base*s.scale, s.MinLR)
+}
+
+// every scheduler in the package implements Scheduler
+var (
+	_ Scheduler    = (*StepDecay)(nil)
+	_ Scheduler    = (*ExponentialDecay)(nil)
+	_ Scheduler    = (*CosineWarmRestarts)(nil)
+	_ Scheduler    = (*LinearWarmup)(nil)
+	_ Scheduler    = (*OneCycle)(nil)
+	_ Scheduler    = (*ReduceOnPlateau)(nil)
+	_ LossObserver = (*ReduceOnPlateau)(nil)
+)
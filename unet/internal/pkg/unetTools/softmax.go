@@ -2,51 +2,133 @@ package unetTools
 
 import (
 	"math"
-
-	"github.com/gonum/matrix/mat64"
 )
 
-// SoftmaxLayer represents a softmax layer
+// SoftmaxLayer represents a softmax layer. Its channels are the classes: at
+// every pixel of every sample it turns the scores of the channels into a
+// probability distribution over them.
 type SoftmaxLayer struct {
-	inputSize  int
-	outputSize int
+	_output *Tensor
 }
 
 // NewSoftmaxLayer initializes a new instance of SoftmaxLayer
-func NewSoftmaxLayer(inputSize, outputSize int) *SoftmaxLayer {
-	softmaxLayer := &SoftmaxLayer{
-		inputSize:  inputSize,
-		outputSize: outputSize,
-	}
-	return softmaxLayer
+func NewSoftmaxLayer() *SoftmaxLayer {
+	return &SoftmaxLayer{}
 }
 
-// Forward performs a forward pass through the SoftmaxLayer
-func (sl *SoftmaxLayer) Forward(input *mat64.Dense) *mat64.Dense {
-	numRows, numCols := input.Dims()
-	output := mat64.NewDense(numRows, numCols, nil)
+// Forward performs a forward pass through the SoftmaxLayer,
+// normalizing the channels of every pixel of every sample
+func (sl *SoftmaxLayer) Forward(input *Tensor) *Tensor {
+	numSamples, numChannels, numRows, numCols := input.Dims()
+	planeSize := numRows * numCols
+	output := NewTensorLike(input)
+
+	parallelFor(numSamples, func(s int) {
+		in := input.Sample(s).Data
+		out := output.Sample(s).Data
+		for i := 0; i < planeSize; i++ {
+			// Compute the maximum score of the pixel
+			maxScore := in[i]
+			for c := 1; c < numChannels; c++ {
+				maxScore = math.Max(maxScore, in[c*planeSize+i])
+			}
 
-	// Iterate over each row of the input matrix
-	for i := 0; i < numRows; i++ {
-		// Compute the maximum score in the row
-		maxScore := input.At(i, 0)
-		for j := 1; j < numCols; j++ {
-			if input.At(i, j) > maxScore {
-				maxScore = input.At(i, j)
+			// Compute the sum of exponentials of scores (for numerical stability)
+			sumExpScores := 0.0
+			for c := 0; c < numChannels; c++ {
+				out[c*planeSize+i] = math.Exp(in[c*planeSize+i] - maxScore)
+				sumExpScores += out[c*planeSize+i]
+			}
+
+			// Compute the softmax scores of the pixel
+			for c := 0; c < numChannels; c++ {
+				out[c*planeSize+i] /= sumExpScores
 			}
 		}
+	})
 
-		// Compute the sum of exponentials of scores (for numerical stability)
-		sumExpScores := 0.0
-		for j := 0; j < numCols; j++ {
-			sumExpScores += math.Exp(input.At(i, j) - maxScore)
+	sl._output = output
+	return output
+}
+
+// Backward performs a backward pass through the SoftmaxLayer
+// using the Jacobian of the softmax of each pixel
+func (sl *SoftmaxLayer) Backward(gradOutput *Tensor) *Tensor {
+	mustSameShape("SoftmaxLayer.Backward", gradOutput, sl._output)
+	numSamples, numChannels, numRows, numCols := gradOutput.Dims()
+	planeSize := numRows * numCols
+	gradInput := NewTensorLike(gradOutput)
+
+	parallelFor(numSamples, func(s int) {
+		y := sl._output.Sample(s).Data
+		gradY := gradOutput.Sample(s).Data
+		gradX := gradInput.Sample(s).Data
+		for i := 0; i < planeSize; i++ {
+			// dL/dx_c = y_c * (dL/dy_c - sum_k dL/dy_k * y_k)
+			dot := 0.0
+			for c := 0; c < numChannels; c++ {
+				dot += gradY[c*planeSize+i] * y[c*planeSize+i]
+			}
+			for c := 0; c < numChannels; c++ {
+				gradX[c*planeSize+i] = y[c*planeSize+i] * (gradY[c*planeSize+i] - dot)
+			}
 		}
+	})
+	return gradInput
+}
 
-		// Compute the softmax scores for the row
-		for j := 0; j < numCols; j++ {
-			output.Set(i, j, math.Exp(input.At(i, j)-maxScore)/sumExpScores)
+// Params returns nil since the SoftmaxLayer has no parameters
+func (sl *SoftmaxLayer) Params() []*Tensor {
+	return nil
+}
+
+// Grads returns nil since the SoftmaxLayer has no parameters
+func (sl *SoftmaxLayer) Grads() []*Tensor {
+	return nil
+}
+
+// Summary returns a string representation of the SoftmaxLayer
+func (sl *SoftmaxLayer) Summary() string {
+	return "	SoftmaxLayer\n"
+}
+
+// ArgmaxChannels returns the N x 1 x H x W label map of t: at every pixel,
+// the index of the channel holding the largest value. Ties go to the lowest
+// channel.
+func ArgmaxChannels(t *Tensor) *Tensor {
+	numSamples, numChannels, numRows, numCols := t.Dims()
+	planeSize := numRows * numCols
+	labels := NewTensor(numSamples, 1, numRows, numCols)
+	for s := 0; s < numSamples; s++ {
+		values := t.Sample(s).Data
+		for i := 0; i < planeSize; i++ {
+			best := 0
+			for c := 1; c < numChannels; c++ {
+				if values[c*planeSize+i] > values[best*planeSize+i] {
+					best = c
+				}
+			}
+			labels.Data[s*planeSize+i] = float64(best)
 		}
 	}
+	return labels
+}
 
-	return output
+// OneHot returns the N x numClasses x H x W one-hot encoding of an
+// N x 1 x H x W label map, such as a target for CategoricalCrossEntropy.
+// Labels outside [0, numClasses) get no class, which leaves their pixels
+// all zeros.
+func OneHot(labels *Tensor, numClasses int) *Tensor {
+	numSamples, _, numRows, numCols := labels.Dims()
+	planeSize := numRows * numCols
+	oneHot := NewTensor(numSamples, numClasses, numRows, numCols)
+	for s := 0; s < numSamples; s++ {
+		out := oneHot.Sample(s).Data
+		for i, label := range labels.Plane(s, 0) {
+			if c := int(label); c >= 0 && c < numClasses && float64(c) == label {
+				out[c*planeSize+i] = 1
+			}
+		}
+	}
+	return oneHot
 }
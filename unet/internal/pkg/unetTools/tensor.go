@@ -0,0 +1,277 @@
+package unetTools
+
+import (
+	"fmt"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// Tensor represents a 4D array of feature maps with shape N x C x H x W
+// (batch, channels, rows, columns). The data is stored contiguously in
+// row-major order, so every (n, c) plane is itself a contiguous H x W block.
+type Tensor struct {
+	Shape   [4]int
+	Strides [4]int
+	Data    []float64
+}
+
+// NewTensor initializes a new zero-filled Tensor with shape n x c x h x w
+func NewTensor(n, c, h, w int) *Tensor {
+	return NewTensorFromData(n, c, h, w, nil)
+}
+
+// NewTensorFromData initializes a new Tensor with shape n x c x h x w backed by data.
+// If data is nil a new zero-filled slice is allocated.
+func NewTensorFromData(n, c, h, w int, data []float64) *Tensor {
+	if n <= 0 || c <= 0 || h <= 0 || w <= 0 {
+		panic(fmt.Sprintf("tensor dimensions must be positive, got %dx%dx%dx%d", n, c, h, w))
+	}
+	size := n * c * h * w
+	if data == nil {
+		data = make([]float64, size)
+	}
+	if len(data) != size {
+		panic(fmt.Sprintf("tensor data has length %d, expected %d for shape %dx%dx%dx%d", len(data), size, n, c, h, w))
+	}
+	return &Tensor{
+		Shape:   [4]int{n, c, h, w},
+		Strides: [4]int{c * h * w, h * w, w, 1},
+		Data:    data,
+	}
+}
+
+// NewTensorLike initializes a new zero-filled Tensor with the same shape as t
+func NewTensorLike(t *Tensor) *Tensor {
+	return NewTensor(t.Dims())
+}
+
+// TensorFromDense builds a 1 x len(channels) x H x W Tensor from a list of
+// equally sized matrices, one per channel. The data is copied.
+func TensorFromDense(channels ...*mat64.Dense) *Tensor {
+	if len(channels) == 0 {
+		panic("TensorFromDense needs at least one channel")
+	}
+	rows, cols := channels[0].Dims()
+	t := NewTensor(1, len(channels), rows, cols)
+	for c, channel := range channels {
+		r, cl := channel.Dims()
+		if r != rows || cl != cols {
+			panic(fmt.Sprintf("channel %d has size %dx%d, expected %dx%d", c, r, cl, rows, cols))
+		}
+		t.Channel(0, c).Copy(channel)
+	}
+	return t
+}
+
+// Dims returns the shape of the Tensor
+func (t *Tensor) Dims() (n, c, h, w int) {
+	return t.Shape[0], t.Shape[1], t.Shape[2], t.Shape[3]
+}
+
+// Len returns the number of elements in the Tensor
+func (t *Tensor) Len() int {
+	return len(t.Data)
+}
+
+// Index returns the offset into Data of element (n, c, h, w)
+func (t *Tensor) Index(n, c, h, w int) int {
+	if n < 0 || n >= t.Shape[0] || c < 0 || c >= t.Shape[1] ||
+		h < 0 || h >= t.Shape[2] || w < 0 || w >= t.Shape[3] {
+		panic(fmt.Sprintf("tensor index (%d, %d, %d, %d) out of range for shape %v", n, c, h, w, t.Shape))
+	}
+	return n*t.Strides[0] + c*t.Strides[1] + h*t.Strides[2] + w*t.Strides[3]
+}
+
+// At returns the element at (n, c, h, w)
+func (t *Tensor) At(n, c, h, w int) float64 {
+	return t.Data[t.Index(n, c, h, w)]
+}
+
+// Set sets the element at (n, c, h, w) to v
+func (t *Tensor) Set(n, c, h, w int, v float64) {
+	t.Data[t.Index(n, c, h, w)] = v
+}
+
+// Plane returns the contiguous H x W slice of Data holding channel c of sample n
+func (t *Tensor) Plane(n, c int) []float64 {
+	start := t.Index(n, c, 0, 0)
+	return t.Data[start : start+t.Strides[1]]
+}
+
+// Channel returns channel c of sample n as an H x W matrix.
+// The matrix shares storage with the Tensor, so writes to it are visible in t.
+func (t *Tensor) Channel(n, c int) *mat64.Dense {
+	return mat64.NewDense(t.Shape[2], t.Shape[3], t.Plane(n, c))
+}
+
+// Sample returns sample n as a 1 x C x H x W Tensor sharing storage with t
+func (t *Tensor) Sample(n int) *Tensor {
+	start := t.Index(n, 0, 0, 0)
+	return NewTensorFromData(1, t.Shape[1], t.Shape[2], t.Shape[3], t.Data[start:start+t.Strides[0]])
+}
+
+// SameShape reports whether t and other have identical shapes
+func (t *Tensor) SameShape(other *Tensor) bool {
+	return t.Shape == other.Shape
+}
+
+// mustSameShape panics if a and b do not have identical shapes
+func mustSameShape(op string, a, b *Tensor) {
+	if !a.SameShape(b) {
+		panic(fmt.Sprintf("%s: tensor shape mismatch %v vs %v", op, a.Shape, b.Shape))
+	}
+}
+
+// Clone returns a deep copy of the Tensor
+func (t *Tensor) Clone() *Tensor {
+	data := make([]float64, len(t.Data))
+	copy(data, t.Data)
+	return NewTensorFromData(t.Shape[0], t.Shape[1], t.Shape[2], t.Shape[3], data)
+}
+
+// Zero sets every element of the Tensor to zero
+func (t *Tensor) Zero() {
+	for i := range t.Data {
+		t.Data[i] = 0
+	}
+}
+
+// Add adds other to t element-wise, in place
+func (t *Tensor) Add(other *Tensor) {
+	mustSameShape("Add", t, other)
+	for i, v := range other.Data {
+		t.Data[i] += v
+	}
+}
+
+// Sub subtracts other from t element-wise, in place
+func (t *Tensor) Sub(other *Tensor) {
+	mustSameShape("Sub", t, other)
+	for i, v := range other.Data {
+		t.Data[i] -= v
+	}
+}
+
+// MulElem multiplies t by other element-wise, in place
+func (t *Tensor) MulElem(other *Tensor) {
+	mustSameShape("MulElem", t, other)
+	for i, v := range other.Data {
+		t.Data[i] *= v
+	}
+}
+
+// Scale multiplies every element of t by f, in place
+func (t *Tensor) Scale(f float64) {
+	for i := range t.Data {
+		t.Data[i] *= f
+	}
+}
+
+// Apply replaces every element of t with fn(element), in place
+func (t *Tensor) Apply(fn func(v float64) float64) {
+	for i, v := range t.Data {
+		t.Data[i] = fn(v)
+	}
+}
+
+// Sum returns the sum of all elements of the Tensor
+func (t *Tensor) Sum() float64 {
+	sum := 0.0
+	for _, v := range t.Data {
+		sum += v
+	}
+	return sum
+}
+
+// StackSamples stacks equally shaped tensors along the batch axis into a
+// single (sum of N) x C x H x W Tensor. The data is copied.
+func StackSamples(samples ...*Tensor) *Tensor {
+	if len(samples) == 0 {
+		panic("StackSamples needs at least one sample")
+	}
+	_, c, h, w := samples[0].Dims()
+	n := 0
+	for _, sample := range samples {
+		sn, sc, sh, sw := sample.Dims()
+		if sc != c || sh != h || sw != w {
+			panic(fmt.Sprintf("StackSamples: tensor shape mismatch %v vs %v", samples[0].Shape, sample.Shape))
+		}
+		n += sn
+	}
+	out := NewTensor(n, c, h, w)
+	offset := 0
+	for _, sample := range samples {
+		offset += copy(out.Data[offset:], sample.Data)
+	}
+	return out
+}
+
+// ConcatChannels concatenates a and b along the channel axis.
+// Both tensors must have the same batch size and spatial size.
+func ConcatChannels(a, b *Tensor) *Tensor {
+	an, ac, ah, aw := a.Dims()
+	bn, bc, bh, bw := b.Dims()
+	if an != bn || ah != bh || aw != bw {
+		panic(fmt.Sprintf("ConcatChannels: tensor shape mismatch %v vs %v", a.Shape, b.Shape))
+	}
+	out := NewTensor(an, ac+bc, ah, aw)
+	for n := 0; n < an; n++ {
+		dst := out.Data[out.Index(n, 0, 0, 0):]
+		copy(dst, a.Data[a.Index(n, 0, 0, 0):a.Index(n, 0, 0, 0)+a.Strides[0]])
+		copy(dst[a.Strides[0]:], b.Data[b.Index(n, 0, 0, 0):b.Index(n, 0, 0, 0)+b.Strides[0]])
+	}
+	return out
+}
+
+// SplitChannels splits t along the channel axis into the first c channels
+// and the remaining channels. It is the inverse of ConcatChannels.
+func SplitChannels(t *Tensor, c int) (*Tensor, *Tensor) {
+	n, tc, h, w := t.Dims()
+	if c <= 0 || c >= tc {
+		panic(fmt.Sprintf("SplitChannels: cannot split %d channels at %d", tc, c))
+	}
+	a := NewTensor(n, c, h, w)
+	b := NewTensor(n, tc-c, h, w)
+	for i := 0; i < n; i++ {
+		src := t.Data[t.Index(i, 0, 0, 0) : t.Index(i, 0, 0, 0)+t.Strides[0]]
+		copy(a.Data[a.Index(i, 0, 0, 0):], src[:a.Strides[0]])
+		copy(b.Data[b.Index(i, 0, 0, 0):], src[a.Strides[0]:])
+	}
+	return a, b
+}
+
+// ResizeTensor resizes every channel of t to h x w using bilinear interpolation
+func ResizeTensor(t *Tensor, h, w int) *Tensor {
+	n, c, th, tw := t.Dims()
+	if th == h && tw == w {
+		return t.Clone()
+	}
+	out := NewTensor(n, c, h, w)
+	for i := 0; i < n; i++ {
+		for j := 0; j < c; j++ {
+			out.Channel(i, j).Copy(ResizeMatrix(t.Channel(i, j), h, w))
+		}
+	}
+	return out
+}
+
+// ResizeTensorBackward computes the gradient of ResizeTensor with respect to its
+// h x w input, given the gradient of its output
+func ResizeTensorBackward(gradOutput *Tensor, h, w int) *Tensor {
+	n, c, gh, gw := gradOutput.Dims()
+	if gh == h && gw == w {
+		return gradOutput.Clone()
+	}
+	gradInput := NewTensor(n, c, h, w)
+	for i := 0; i < n; i++ {
+		for j := 0; j < c; j++ {
+			gradInput.Channel(i, j).Copy(ResizeMatrixBackward(gradOutput.Channel(i, j), h, w))
+		}
+	}
+	return gradInput
+}
+
+// String returns a short description of the Tensor
+func (t *Tensor) String() string {
+	return fmt.Sprintf("Tensor%v", t.Shape)
+}
@@ -0,0 +1,93 @@
+package unetTools
+
+import (
+	"math"
+)
+
+// Tversky is the Tversky loss, 1 - (TP + Smooth) / (TP + Alpha*FP + Beta*FN + Smooth),
+// taken over every element of the prediction, with the soft counts
+//
+//	TP = sum(p*t)  FP = sum(p*(1-t))  FN = sum((1-p)*t)
+//
+// Alpha weighs the false positives and Beta the false negatives: a Beta above
+// Alpha favours recall on small foreground masks. Alpha = Beta = 0.5 is Dice
+// with twice the smoothing.
+type Tversky struct {
+	Alpha  float64
+	Beta   float64
+	Smooth float64
+}
+
+// NewTversky initializes a new instance of Tversky
+func NewTversky(alpha, beta, smooth float64) Tversky {
+	return Tversky{Alpha: alpha, Beta: beta, Smooth: smooth}
+}
+
+// index returns the Tversky index of pred, with its numerator and denominator
+func (tv Tversky) index(pred, target *Tensor) (index, numerator, denominator float64) {
+	mustSameShape("Tversky", pred, target)
+	tp, fp, fn := 0.0, 0.0, 0.0
+	for i, p := range pred.Data {
+		t := target.Data[i]
+		tp += p * t
+		fp += p * (1 - t)
+		fn += (1 - p) * t
+	}
+	numerator = tp + tv.Smooth
+	denominator = tp + tv.Alpha*fp + tv.Beta*fn + tv.Smooth
+	return numerator / denominator, numerator, denominator
+}
+
+// indexGrad returns the gradient of the Tversky index with respect to pred, scaled by scale
+func (tv Tversky) indexGrad(pred, target *Tensor, numerator, denominator, scale float64) *Tensor {
+	grad := NewTensorLike(pred)
+	for i, t := range target.Data {
+		dDenominator := t + tv.Alpha*(1-t) - tv.Beta*t
+		grad.Data[i] = scale * (t*denominator - numerator*dDenominator) / (denominator * denominator)
+	}
+	return grad
+}
+
+// Value returns the Tversky loss of pred
+func (tv Tversky) Value(pred, target *Tensor) float64 {
+	index, _, _ := tv.index(pred, target)
+	return 1 - index
+}
+
+// Grad returns the gradient of the Tversky loss with respect to pred
+func (tv Tversky) Grad(pred, target *Tensor) *Tensor {
+	_, numerator, denominator := tv.index(pred, target)
+	return tv.indexGrad(pred, target, numerator, denominator, -1)
+}
+
+// FocalTversky is the focal Tversky loss, (1 - TI)^Gamma with TI the Tversky
+// index. A Gamma below 1 raises the loss, and its gradient, on the examples
+// that are already mostly right, which helps on small structures; a Gamma of
+// 1 is the Tversky loss.
+type FocalTversky struct {
+	Tversky
+	Gamma float64
+}
+
+// NewFocalTversky initializes a new instance of FocalTversky
+func NewFocalTversky(alpha, beta, gamma, smooth float64) FocalTversky {
+	return FocalTversky{Tversky: NewTversky(alpha, beta, smooth), Gamma: gamma}
+}
+
+// Value returns the focal Tversky loss of pred
+func (ft FocalTversky) Value(pred, target *Tensor) float64 {
+	index, _, _ := ft.index(pred, target)
+	return math.Pow(1-index, ft.Gamma)
+}
+
+// Grad returns the gradient of the focal Tversky loss with respect to pred:
+// -Gamma * (1 - TI)^(Gamma-1) times the gradient of TI. It is 0 when the
+// prediction is perfect, where it is not defined for a Gamma below 1.
+func (ft FocalTversky) Grad(pred, target *Tensor) *Tensor {
+	index, numerator, denominator := ft.index(pred, target)
+	if 1-index <= 0 {
+		return NewTensorLike(pred)
+	}
+	scale := -ft.Gamma * math.Pow(1-index, ft.Gamma-1)
+	return ft.indexGrad(pred, target, numerator, denominator, scale)
+}
@@ -3,9 +3,8 @@ package unetTools
 import (
 	"fmt"
 	"math"
+	"math/rand"
 	"slices"
-
-	"github.com/gonum/matrix/mat64"
 )
 
 // UnetParams represents the parameters for a U-Net model
@@ -25,20 +24,165 @@ type Unet struct {
 	poolSize         int     // Size of pooling kernel
 	poolStride       int     // Stride of pooling kernel
 	learningRate     float64 // Learning rate
+	numClasses       int     // Number of output classes
 	lossTolerance    float64 // Loss tolerance (will exit if loss less than this value)
 	maxIterations    int     // Maximum number of iterations
+	padding          string  // Padding mode of the convolutions
+	lossReduction    string  // Reduction of the per-sample losses of a batch
+
+	optimizer Optimizer // Updates the parameters from their gradients
+	scheduler Scheduler // Learning rate of every step, nil keeps it constant
+	clipValue float64   // Bound on every gradient element, 0 disables it
+	clipNorm  float64   // Bound on the global L2 norm of the gradients, 0 disables it
+
+	rng *rand.Rand // Source of the initial parameters
+
+	deterministic bool                  // Every random stream derives from seed
+	seed          int64                 // Seed of the deterministic mode
+	streams       map[string]*rand.Rand // Random sources by stream, see Rand
+	weightInit    string                // Initializer of the weights, "" picks one for the activation
+	biasInit      string                // Initializer of the biases
 
-	lossFunc func(*mat64.Dense, *mat64.Dense) float64 // Loss function
+	loss Loss // Loss function, compared on every sample
 
 	//internal params
-	_steps int
-	_loss  float64
-	_stop  bool
+	_steps        int // optimizer steps taken
+	_loss         float64
+	_stop         bool
+	_learningRate float64 // learning rate of the last step
+	_gradNorm     float64 // global gradient norm of the last step, before clipping
 
 	encoders   []*Encoder
 	bottleneck *Decoder
 	decoders   []*Decoder
 	finalConv  *ConvLayer
+	softmax    *SoftmaxLayer // normalizes the classes, nil with a single class
+	tape       *Tape         // records the last forward pass for Backward
+}
+
+// UnetOption configures an optional setting of a Unet
+type UnetOption func(*Unet)
+
+// WithPadding sets the padding mode of the convolutions (default PaddingSame).
+// With PaddingValid every convolution shrinks the feature maps, and the skip
+// features are resized to fit the decoders.
+func WithPadding(padding string) UnetOption {
+	return func(unet *Unet) {
+		checkPadding(padding)
+		unet.padding = padding
+	}
+}
+
+// WithLossReduction sets how the losses of the samples of a batch are reduced
+// (default LossReductionMean, which averages the gradients over the batch)
+func WithLossReduction(reduction string) UnetOption {
+	return func(unet *Unet) {
+		checkLossReduction(reduction)
+		unet.lossReduction = reduction
+	}
+}
+
+// WithOptimizer sets the optimizer that updates the parameters
+// (default AdamW with beta1 0.9, beta2 0.999, epsilon 1e-8 and weight decay 0.01)
+func WithOptimizer(optimizer Optimizer) UnetOption {
+	return func(unet *Unet) {
+		unet.optimizer = optimizer
+	}
+}
+
+// WithScheduler sets the learning rate schedule, applied to the base learning
+// rate at every optimizer step (default none, the learning rate stays constant)
+func WithScheduler(scheduler Scheduler) UnetOption {
+	return func(unet *Unet) {
+		unet.scheduler = scheduler
+	}
+}
+
+// WithGradClipValue clamps every gradient element to [-limit, limit] before
+// the optimizer update
+func WithGradClipValue(limit float64) UnetOption {
+	return func(unet *Unet) {
+		if limit <= 0 {
+			panic("gradient clipping limit must be positive")
+		}
+		unet.clipValue = limit
+	}
+}
+
+// WithGradClipNorm scales the gradients of all parameters together so that
+// their global L2 norm is at most maxNorm before the optimizer update
+func WithGradClipNorm(maxNorm float64) UnetOption {
+	return func(unet *Unet) {
+		if maxNorm <= 0 {
+			panic("gradient clipping norm must be positive")
+		}
+		unet.clipNorm = maxNorm
+	}
+}
+
+// WithSeed seeds the random source of the initial parameters, so that two
+// models built with the same seed and options start out identical
+// (default a seed drawn from math/rand)
+func WithSeed(seed int64) UnetOption {
+	return func(unet *Unet) {
+		unet.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithDeterministic makes training reproducible: two models built with the
+// same seed and options, fed the same data, end up with bit-identical
+// parameters. Every random stream of the model (RandInit, RandShuffle,
+// RandAugment, RandDropout, see Unet.Rand) is seeded from seed.
+//
+// Reductions always run in a fixed order, so they need no switch: parallel
+// work is split into fixed units that never share an output (see
+// SetNumWorkers), the tape and the optimizers only look tensors up in their
+// maps and never iterate over them, and gradients and losses are summed in
+// parameter and sample order.
+func WithDeterministic(seed int64) UnetOption {
+	return func(unet *Unet) {
+		unet.deterministic = true
+		unet.seed = seed
+		unet.streams = nil
+		unet.rng = unet.Rand(RandInit)
+	}
+}
+
+// WithInitializer sets the initializers of the weights and biases of every
+// layer, see the Init* constants. By default the weights use InitHeNormal in
+// front of relu-like activations and InitXavierNormal otherwise, and the
+// biases use InitZeros.
+func WithInitializer(weightInit, biasInit string) UnetOption {
+	return func(unet *Unet) {
+		checkInit(weightInit)
+		checkInit(biasInit)
+		unet.weightInit = weightInit
+		unet.biasInit = biasInit
+	}
+}
+
+// WithNumClasses sets the number of classes the model segments (default 1).
+// With a single class the final 1x1 convolution has one sigmoid output, the
+// probability of the foreground. With more, it has one output per class,
+// and a SoftmaxLayer turns them into a probability distribution over the
+// classes at every pixel; train it against one-hot targets (see OneHot),
+// for instance with CategoricalCrossEntropy.
+func WithNumClasses(numClasses int) UnetOption {
+	return func(unet *Unet) {
+		if numClasses < 1 {
+			panic("number of classes must be positive")
+		}
+		unet.numClasses = numClasses
+	}
+}
+
+// defaultWeightInit returns the weight initializer suited to activation
+func defaultWeightInit(activation string) string {
+	switch activation {
+	case "relu", "leakyrelu", "elu", "gelu":
+		return InitHeNormal
+	}
+	return InitXavierNormal
 }
 
 // NewUnet initializes a new instance of Unet
@@ -52,29 +196,10 @@ func NewUnet(
 	poolSize int,
 	poolStride int,
 	learningRate float64,
-	lossFunc func(*mat64.Dense, *mat64.Dense) float64,
+	loss Loss,
+	options ...UnetOption,
 ) *Unet {
 
-	cl_params := ConvParams{
-		activation,
-		inputChannels,
-		kernelSize,
-		numFiltersLayer1,
-		0.9,
-		0.999,
-		1e-8,
-	}
-	ctl_params := ConvTransParams{
-		activation,
-		inputChannels,
-		kernelSize,
-		poolStride,
-		numFiltersLayer1,
-		0.9,
-		0.999,
-		1e-8,
-	}
-
 	unet := &Unet{
 		inputSize:        inputSize,
 		inputChannels:    inputChannels,
@@ -85,41 +210,108 @@ func NewUnet(
 		poolSize:         poolSize,
 		poolStride:       poolStride,
 		learningRate:     learningRate,
-		lossFunc:         lossFunc,
+		numClasses:       1,
+		padding:          PaddingSame,
+		lossReduction:    LossReductionMean,
+		optimizer:        NewAdamW(0.9, 0.999, 1e-8, 0.01),
+		biasInit:         InitZeros,
+		loss:             loss,
 
 		encoders: make([]*Encoder, numEnDecoders),
-		bottleneck: NewDecoder(
-			[]ConvParams{cl_params, cl_params},
-			[]ConvTransParams{ctl_params},
-		),
 		decoders: make([]*Decoder, numEnDecoders),
-		finalConv: NewConvLayer(
-			1, 1, 1, "sigmoid",
-		), // final conv layer is a 1x1 convolution with 1 filter
+		tape:     NewTape(),
+	}
+	for _, option := range options {
+		option(unet)
+	}
+	if unet.rng == nil {
+		unet.rng = unet.Rand(RandInit)
+	}
+	weightInit := unet.weightInit
+	if weightInit == "" {
+		weightInit = defaultWeightInit(activation)
+	}
+
+	cl_params := ConvParams{
+		Activation:    activation,
+		InputChannels: inputChannels,
+		KernelSize:    kernelSize,
+		NumFilters:    numFiltersLayer1,
+		Padding:       unet.padding,
+		WeightInit:    weightInit,
+		BiasInit:      unet.biasInit,
+		Rand:          unet.rng,
+	}
+	// the upsampling kernel matches the pooling stride, so that padded
+	// encoder outputs and decoder inputs align exactly
+	ctl_params := ConvTransParams{
+		Activation:    activation,
+		InputChannels: inputChannels,
+		KernelSize:    poolStride,
+		Stride:        poolStride,
+		NumFilters:    numFiltersLayer1,
+		WeightInit:    weightInit,
+		BiasInit:      unet.biasInit,
+		Rand:          unet.rng,
+	}
+
+	// convPair returns the parameters of the two convolutions of a stage
+	// whose input has inChannels channels
+	convPair := func(inChannels int) []ConvParams {
+		first, second := cl_params, cl_params
+		first.InputChannels = inChannels
+		second.InputChannels = cl_params.NumFilters
+		return []ConvParams{first, second}
 	}
 
 	// build the encoder-decoder pairs
+	channels := inputChannels
 	for i := 0; i < numEnDecoders; i++ {
 		unet.encoders[i] = NewEncoder(
-			[]ConvParams{cl_params, cl_params},
+			convPair(channels),
 			[]PoolParams{{poolSize, poolStride}},
 		)
+		channels = cl_params.NumFilters
 		cl_params.NumFilters *= 2
 
 	}
 	unet.bottleneck = NewDecoder(
-		[]ConvParams{cl_params, cl_params},
+		convPair(channels),
 		[]ConvTransParams{},
 	)
 	for i := 0; i < numEnDecoders; i++ {
+		// the upsampling layer consumes the output of the previous stage
+		ctl_params.InputChannels = cl_params.NumFilters
 		cl_params.NumFilters /= 2
 		ctl_params.NumFilters = cl_params.NumFilters
+		// the first convolution sees the upsampled features and the skip features
 		unet.decoders[i] = NewDecoder(
-			[]ConvParams{cl_params, cl_params},
+			convPair(2*cl_params.NumFilters),
 			[]ConvTransParams{ctl_params},
 		)
 	}
 
+	// final conv layer is a 1x1 convolution with 1 filter per class,
+	// followed by a softmax over the classes if there are several
+	finalActivation := "sigmoid"
+	if unet.numClasses > 1 {
+		finalActivation = "identity"
+		unet.softmax = NewSoftmaxLayer()
+	}
+	finalWeightInit := unet.weightInit
+	if finalWeightInit == "" {
+		finalWeightInit = defaultWeightInit(finalActivation)
+	}
+	unet.finalConv = NewConvLayerFromParams(ConvParams{
+		Activation:    finalActivation,
+		InputChannels: numFiltersLayer1,
+		KernelSize:    1,
+		NumFilters:    unet.numClasses,
+		WeightInit:    finalWeightInit,
+		BiasInit:      unet.biasInit,
+		Rand:          unet.rng,
+	})
+
 	unet._steps = 0
 	unet._loss = math.Inf(1) // positive infinity
 	unet._stop = false
@@ -127,85 +319,197 @@ func NewUnet(
 	return unet
 }
 
-// Forward performs a forward pass through the U-Net model
-func (unet *Unet) Forward(input *mat64.Dense) []*mat64.Dense {
+// Forward performs a forward pass through the U-Net model on a batch of
+// N x inputChannels x H x W samples and returns the N x numClasses x H x W
+// probability maps (see WithNumClasses). Every operation is recorded on the
+// model's tape for the next Backward.
+func (unet *Unet) Forward(input *Tensor) *Tensor {
+	unet.tape.Reset()
 
-	// pass through encoders
-	var output []*mat64.Dense
-	var encoder_outputs [][]*mat64.Dense
-	output = append(output, input)
+	// pass through encoders, keeping the features before pooling for the skip connections
+	output := input
+	var encoder_outputs []*Tensor
 	for i := 0; i < unet.numEnDecoders; i++ {
-		output = unet.encoders[i].Forward(output)
-		encoder_outputs = append(encoder_outputs, nil)
-		copy(encoder_outputs[i], output)
+		var skip *Tensor
+		output, skip = unet.encoders[i].forward(unet.tape, output)
+		encoder_outputs = append(encoder_outputs, skip)
 	}
 	slices.Reverse(encoder_outputs)
 
 	// handle bottleneck
 	// bottleneck doesn't have a skip connection
-	output = unet.bottleneck.Forward(output, nil)
+	output = unet.bottleneck.forward(unet.tape, output, nil)
 
 	// pass through decoders
 	for i := 0; i < unet.numEnDecoders; i++ {
-		output = unet.decoders[i].Forward(output, encoder_outputs[i])
+		output = unet.decoders[i].forward(unet.tape, output, encoder_outputs[i])
 	}
 
 	// final convolution
-	output = unet.finalConv.Forward(output)
+	output = unet.tape.Layer(unet.finalConv, output)
+	if unet.softmax != nil {
+		output = unet.tape.Layer(unet.softmax, output)
+	}
 	return output
 }
 
-// Backward performs a backward pass through the U-Net model
-func (unet *Unet) Backward(input *mat64.Dense, target *mat64.Dense, loss float64) {
-	// compute gradients for both weights and biases
-	gradOutput := mat64.NewDense(target.RawMatrix().Rows, target.RawMatrix().Cols, nil)
-	ir, ic := input.Dims()
-	tr, tc := target.Dims()
-	if ir != tr || ic != tc {
-		input = ResizeMatrix(input, tr, tc)
+// Labels returns the N x 1 x H x W label map of the probability maps
+// returned by Forward: the most likely class of every pixel. With a single
+// class, a pixel is labelled 1 if its probability is at least 0.5, else 0.
+func (unet *Unet) Labels(probabilities *Tensor) *Tensor {
+	if unet.numClasses > 1 {
+		return ArgmaxChannels(probabilities)
+	}
+	labels := probabilities.Clone()
+	labels.Apply(func(p float64) float64 {
+		if p >= 0.5 {
+			return 1
+		}
+		return 0
+	})
+	return labels
+}
+
+// Predict runs the model on a batch of samples and returns both its
+// probability maps and its label maps, see Forward and Labels
+func (unet *Unet) Predict(input *Tensor) (probabilities, labels *Tensor) {
+	probabilities = unet.Forward(input)
+	return probabilities, unet.Labels(probabilities)
+}
+
+// Loss computes the loss between output (as returned by the last Forward) and
+// target, one sample at a time with the Loss given to NewUnet, and records it
+// on the tape. Backward is seeded from the gradient of that Loss. If the
+// sizes of output and target differ, the output is resized to the size of
+// target first. The per-sample losses are reduced as set by
+// WithLossReduction; see Tape.BatchLoss for the shape of the returned Tensor.
+//
+// If the Loss is a StepObserver, it is told the number of optimizer steps
+// taken before the losses are computed.
+func (unet *Unet) Loss(output *Tensor, target *Tensor) *Tensor {
+	pred := output
+	if _, _, rows, cols := target.Dims(); !output.SameShape(target) {
+		pred = unet.tape.Resize(output, rows, cols)
+	}
+	if observer, ok := unet.loss.(StepObserver); ok {
+		observer.ObserveStep(unet._steps)
+	}
+	return unet.tape.BatchLoss(pred, target, unet.lossReduction, unet.loss.Value, unet.loss.Grad)
+}
+
+// Backward performs a backward pass through the U-Net model by replaying
+// the tape from loss (as returned by Loss), clips the gradients if enabled,
+// then lets the optimizer update every parameter and resets the gradients.
+// The tape splits the gradient of every decoder at its concatenation point
+// and adds the skip part to the gradient of the matching encoder.
+// The gradients of the samples of a batch are reduced like their losses, so
+// with LossReductionMean they are averaged before the update.
+// The learning rate is the scheduled learning rate of the next step.
+func (unet *Unet) Backward(loss *Tensor) {
+	unet.backward(loss, unet.learningRate)
+}
+
+// backward replays the tape from loss and takes one optimizer step with the
+// learning rate scheduled from base
+func (unet *Unet) backward(loss *Tensor, base float64) {
+	unet.tape.Backward(loss)
+
+	// apply the accumulated gradients
+	unet._learningRate = base
+	if unet.scheduler != nil {
+		unet._learningRate = unet.scheduler.LearningRate(unet._steps, base)
+	}
+	fmt.Println("UNet learning rate", unet._learningRate)
+	grads := unet.Grads()
+
+	// clip the gradients, recording their norm before clipping (see GetGradNorm)
+	unet._gradNorm = GradNorm(grads)
+	if unet.clipValue > 0 {
+		ClipGradValue(grads, unet.clipValue)
+	}
+	if unet.clipNorm > 0 {
+		ClipGradNorm(grads, unet.clipNorm)
 	}
-	gradOutput.Sub(target, input)
-	gradOutput.Scale(-1*loss, gradOutput)
-	gradOutput = ResizeMatrix(gradOutput, ir, ic)
+	unet.optimizer.Update(unet.Params(), grads, unet._learningRate)
+	for _, grad := range grads {
+		grad.Zero()
+	}
+	unet._steps++
+}
 
-	fmt.Println("UNet learning rate", unet.learningRate)
-	unet.finalConv.Backward(gradOutput, unet.learningRate)
+// Params returns the parameters of every layer of the U-Net model
+func (unet *Unet) Params() []*Tensor {
+	var params []*Tensor
+	for _, encode := range unet.encoders {
+		params = append(params, encode.Params()...)
+	}
+	params = append(params, unet.bottleneck.Params()...)
+	for _, decode := range unet.decoders {
+		params = append(params, decode.Params()...)
+	}
+	return append(params, unet.finalConv.Params()...)
+}
 
-	for i := len(unet.decoders) - 1; i >= 0; i-- {
-		unet.decoders[i].Backward(gradOutput, unet.learningRate)
+// Grads returns the gradients of every layer of the U-Net model, in the same order as Params
+func (unet *Unet) Grads() []*Tensor {
+	var grads []*Tensor
+	for _, encode := range unet.encoders {
+		grads = append(grads, encode.Grads()...)
 	}
-	unet.bottleneck.Backward(gradOutput, unet.learningRate)
-	for i := len(unet.encoders) - 1; i >= 0; i-- {
-		unet.encoders[i].Backward(gradOutput, unet.learningRate)
+	grads = append(grads, unet.bottleneck.Grads()...)
+	for _, decode := range unet.decoders {
+		grads = append(grads, decode.Grads()...)
 	}
+	return append(grads, unet.finalConv.Grads()...)
 }
 
-// Step performs a forward and backward pass through the U-Net model
-// followed by an update call
+// Step performs a forward and backward pass through the U-Net model on a
+// batch of N samples followed by an update call. input and target hold the
+// samples along their first axis (see StackSamples). It returns the reduced
+// loss of the batch, or the mean per-sample loss with LossReductionNone.
+// learningRate is the base learning rate that the scheduler, if any, adjusts.
 func (unet *Unet) Step(
-	input *mat64.Dense,
-	target *mat64.Dense,
+	input *Tensor,
+	target *Tensor,
 	learningRate float64,
 ) float64 {
 	fmt.Println("[INFO] UNet Forward:")
 	output := unet.Forward(input)
-	SaveImage(output[0], "output.png")
+	if unet.numClasses > 1 {
+		SaveImage(unet.Labels(output).Channel(0, 0), "output.png")
+	} else {
+		SaveImage(output.Channel(0, 0), "output.png")
+	}
 	// compute loss
-	unet._loss = unet.lossFunc(output[0], target)
+	loss := unet.Loss(output, target)
+	unet._loss = loss.Sum() / float64(loss.Len())
 	fmt.Println("[INFO] UNet Loss:", unet._loss)
+	if composite, ok := unet.loss.(*CompositeLoss); ok {
+		for _, component := range composite.Components() {
+			fmt.Printf("[INFO] UNet Loss %s: %v (weight %v)\n", component.Name, component.Value, component.Weight)
+		}
+	}
 	fmt.Println("[INFO] UNet Backward:")
-	unet.Backward(output[0], target, unet._loss)
-	unet._steps++
+	unet.backward(loss, learningRate)
+	fmt.Println("[INFO] UNet Gradient Norm:", unet._gradNorm)
 	if unet._steps > unet.maxIterations || unet._loss < unet.lossTolerance {
 		unet._stop = true
 	}
-	/*
-		This would be the place to add a check for convergence,
-		update the learning rate, etc.
-	*/
 	return unet._loss
 }
 
+// Validate computes the loss of the model on a validation batch without
+// updating it, and reports it to the scheduler if it is a LossObserver.
+// It replaces whatever the tape recorded, so call it between steps.
+func (unet *Unet) Validate(input *Tensor, target *Tensor) float64 {
+	loss := unet.Loss(unet.Forward(input), target)
+	validationLoss := loss.Sum() / float64(loss.Len())
+	if observer, ok := unet.scheduler.(LossObserver); ok {
+		observer.Observe(validationLoss)
+	}
+	return validationLoss
+}
+
 // Summary returns a string representation of the U-Net model
 func (unet *Unet) Summary() string {
 	// print summary of each encoder
@@ -219,7 +523,28 @@ func (unet *Unet) Summary() string {
 	return "Unet"
 }
 
+// NumClasses returns the number of classes the model segments
+func (unet *Unet) NumClasses() int {
+	return unet.numClasses
+}
+
 // GetLoss returns the current loss of the U-Net model
 func (unet *Unet) GetLoss() float64 {
 	return unet._loss
 }
+
+// GetGradNorm returns the global L2 norm of the gradients of the last
+// optimizer step, before clipping
+func (unet *Unet) GetGradNorm() float64 {
+	return unet._gradNorm
+}
+
+// Deterministic reports whether the model was built WithDeterministic
+func (unet *Unet) Deterministic() bool {
+	return unet.deterministic
+}
+
+// GetLearningRate returns the learning rate of the last optimizer step
+func (unet *Unet) GetLearningRate() float64 {
+	return unet._learningRate
+}
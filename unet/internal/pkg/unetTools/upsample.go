@@ -16,6 +16,7 @@ type UpsampleParams struct {
 type UpsampleLayer struct {
 	kernelSize int
 	stride     int
+	_input     *Tensor
 }
 
 // NewUpsampleLayer initializes a new instance of UpsampleLayer
@@ -28,27 +29,32 @@ func NewUpsampleLayer(kernelSize int, stride int) *UpsampleLayer {
 
 // Forward performs a forward pass through the UpsampleLayer
 // by applying a kernelSize x kernelSize upsampling kernel
-// with stride stride
-func (ul *UpsampleLayer) Forward(input *mat64.Dense) *mat64.Dense {
+// with stride stride to every channel of every sample
+func (ul *UpsampleLayer) Forward(input *Tensor) *Tensor {
+	ul._input = input
 	// output size
-	in_rows, in_cols := input.Dims()
+	numSamples, numChannels, in_rows, in_cols := input.Dims()
 	out_rows := (in_rows-1)*ul.stride + ul.kernelSize
 	out_cols := (in_cols-1)*ul.stride + ul.kernelSize
 	// create output
-	output := mat64.NewDense(out_rows, out_cols, nil)
+	output := NewTensor(numSamples, numChannels, out_rows, out_cols)
 	kernel := getUpsampleKernel(ul.kernelSize)
 
 	// fill output
-	for i := 0; i < in_rows; i++ {
-		out_i := i * ul.stride
-		for j := 0; j < in_cols; j++ {
-			out_j := j * ul.stride
-			// copy input value to output
-			for k := 0; k < ul.kernelSize; k++ {
-				for l := 0; l < ul.kernelSize; l++ {
-					value := output.At(out_i+k, out_j+l)
-					value += kernel.At(k, l) * input.At(i, j)
-					output.Set(out_i+k, out_j+l, value)
+	for s := 0; s < numSamples; s++ {
+		for c := 0; c < numChannels; c++ {
+			for i := 0; i < in_rows; i++ {
+				out_i := i * ul.stride
+				for j := 0; j < in_cols; j++ {
+					out_j := j * ul.stride
+					// copy input value to output
+					for k := 0; k < ul.kernelSize; k++ {
+						for l := 0; l < ul.kernelSize; l++ {
+							value := output.At(s, c, out_i+k, out_j+l)
+							value += kernel.At(k, l) * input.At(s, c, i, j)
+							output.Set(s, c, out_i+k, out_j+l, value)
+						}
+					}
 				}
 			}
 		}
@@ -56,9 +62,32 @@ func (ul *UpsampleLayer) Forward(input *mat64.Dense) *mat64.Dense {
 	return output
 }
 
-// Backward performs a backward pass through the UpsampleLayer
-func (ul *UpsampleLayer) Backward(gradOutput *mat64.Dense) *mat64.Dense {
-	return gradOutput
+// Backward performs a backward pass through the UpsampleLayer.
+// Every input element receives the kernel-weighted sum of the
+// gradients of the output elements it was spread over.
+func (ul *UpsampleLayer) Backward(gradOutput *Tensor) *Tensor {
+	numSamples, numChannels, in_rows, in_cols := ul._input.Dims()
+	gradInput := NewTensorLike(ul._input)
+	kernel := getUpsampleKernel(ul.kernelSize)
+
+	for s := 0; s < numSamples; s++ {
+		for c := 0; c < numChannels; c++ {
+			for i := 0; i < in_rows; i++ {
+				out_i := i * ul.stride
+				for j := 0; j < in_cols; j++ {
+					out_j := j * ul.stride
+					sum := 0.0
+					for k := 0; k < ul.kernelSize; k++ {
+						for l := 0; l < ul.kernelSize; l++ {
+							sum += kernel.At(k, l) * gradOutput.At(s, c, out_i+k, out_j+l)
+						}
+					}
+					gradInput.Set(s, c, i, j, sum)
+				}
+			}
+		}
+	}
+	return gradInput
 }
 
 // getUpsampleKernel returns a kernel for upsampling
@@ -82,6 +111,16 @@ func getUpsampleKernel(kernelSize int) *mat64.Dense {
 	return kernel
 }
 
+// Params returns nil since the UpsampleLayer has no parameters
+func (ul *UpsampleLayer) Params() []*Tensor {
+	return nil
+}
+
+// Grads returns nil since the UpsampleLayer has no parameters
+func (ul *UpsampleLayer) Grads() []*Tensor {
+	return nil
+}
+
 // Summary returns a summary of the UpsampleLayer
 func (ul *UpsampleLayer) Summary() string {
 	ret := "  UpsampleLayer:\n"
@@ -0,0 +1,85 @@
+package unetTools_test
+
+import (
+	"math"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestActivationDerivatives(t *testing.T) {
+	const h = 1e-6
+	names := []string{"identity", "relu", "leakyrelu", "elu", "gelu", "tanh", "softplus", "sigmoid"}
+	// avoid the kinks at 0
+	points := []float64{-3, -0.7, -0.1, 0.2, 1.5, 4}
+	for _, name := range names {
+		activation, err := unetTools.GetActivation(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, x := range points {
+			numeric := (activation.Forward(x+h) - activation.Forward(x-h)) / (2 * h)
+			if math.Abs(numeric-activation.Derivative(x)) > 1e-6 {
+				t.Errorf("%s: derivative at %v is %v, numeric %v", name, x, activation.Derivative(x), numeric)
+			}
+		}
+	}
+}
+
+func TestActivationValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		x, want float64
+	}{
+		{"relu", 0.3, 0.3}, // the threshold is 0, not 0.5
+		{"relu", -0.3, 0},
+		{"leakyrelu", -2, -0.02},
+		{"elu", -1, math.Exp(-1) - 1},
+		{"gelu", 1, 0.5 * (1 + math.Erf(1/math.Sqrt2))},
+		{"tanh", 0.5, math.Tanh(0.5)},
+		{"softplus", 0, math.Log(2)},
+		{"softplus", 1000, 1000},
+		{"sigmoid", 0, 0.5},
+		{"sigmoid", -1000, 0},
+		{"identity", -1.5, -1.5},
+	}
+	for _, c := range cases {
+		activation, _ := unetTools.GetActivation(c.name)
+		if got := activation.Forward(c.x); math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("%s(%v): expected %v, but got %v", c.name, c.x, c.want, got)
+		}
+	}
+}
+
+func TestUnknownActivation(t *testing.T) {
+	if _, err := unetTools.GetActivation("swish"); err == nil {
+		t.Errorf("Expected an error for an unknown activation")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected NewConvLayer to panic on an unknown activation")
+		}
+	}()
+	unetTools.NewConvLayer(1, 3, 1, "swish")
+}
+
+// square is a custom activation used to test the registry
+type square struct{}
+
+func (square) Forward(x float64) float64    { return x * x }
+func (square) Derivative(x float64) float64 { return 2 * x }
+
+func TestRegisterActivation(t *testing.T) {
+	unetTools.RegisterActivation("square", square{})
+
+	cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+		Activation: "square", InputChannels: 1, KernelSize: 1, NumFilters: 1,
+	})
+	cl.Weights.Data[0] = 1
+	cl.Biases.Data[0] = 0
+	output := cl.Forward(unetTools.NewTensorFromData(1, 1, 1, 2, []float64{-3, 2}))
+
+	if output.Data[0] != 9 || output.Data[1] != 4 {
+		t.Errorf("Expected [9 4], but got %v", output.Data)
+	}
+}
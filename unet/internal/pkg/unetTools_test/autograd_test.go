@@ -0,0 +1,141 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// randomTensor returns an n x c x h x w Tensor filled with values in [-1, 1)
+func randomTensor(rng *rand.Rand, n, c, h, w int) *unetTools.Tensor {
+	t := unetTools.NewTensor(n, c, h, w)
+	for i := range t.Data {
+		t.Data[i] = 2*rng.Float64() - 1
+	}
+	return t
+}
+
+// sumOfSquares is a simple loss used to check gradients
+func sumOfSquares(pred, _ *unetTools.Tensor) float64 {
+	sum := 0.0
+	for _, v := range pred.Data {
+		sum += v * v
+	}
+	return sum / 2
+}
+
+// sumOfSquaresGrad is the gradient of sumOfSquares
+func sumOfSquaresGrad(pred, _ *unetTools.Tensor) *unetTools.Tensor {
+	return pred.Clone()
+}
+
+// checkGradient compares analytic against the central finite difference of f with respect to x
+func checkGradient(t *testing.T, name string, f func() float64, x, analytic *unetTools.Tensor) {
+	t.Helper()
+	const h = 1e-6
+	if analytic == nil {
+		t.Fatalf("%s: no gradient was computed", name)
+	}
+	if !analytic.SameShape(x) {
+		t.Fatalf("%s: gradient has shape %v, expected %v", name, analytic.Shape, x.Shape)
+	}
+	for i := range x.Data {
+		orig := x.Data[i]
+		x.Data[i] = orig + h
+		plus := f()
+		x.Data[i] = orig - h
+		minus := f()
+		x.Data[i] = orig
+		numeric := (plus - minus) / (2 * h)
+		if math.Abs(numeric-analytic.Data[i]) > 1e-5*math.Max(1, math.Abs(numeric)) {
+			t.Fatalf("%s: gradient mismatch at %d: analytic %g, numeric %g", name, i, analytic.Data[i], numeric)
+		}
+	}
+}
+
+func TestTapeRecordsOperations(t *testing.T) {
+	tape := unetTools.NewTape()
+	x := unetTools.NewTensor(1, 2, 4, 4)
+
+	y := tape.Resize(x, 6, 6)
+	y = tape.Concat(y, tape.Activation(y, "sigmoid"))
+	_ = tape.Loss(y, nil, sumOfSquares, sumOfSquaresGrad)
+
+	expected := []string{"resize", "activation", "concat", "loss"}
+	ops := tape.Ops()
+	if len(ops) != len(expected) {
+		t.Fatalf("Expected ops %v, but got %v", expected, ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Errorf("Expected op %d to be %s, but got %s", i, expected[i], ops[i])
+		}
+	}
+}
+
+func TestTapeGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := randomTensor(rng, 2, 2, 4, 5)
+	upsample := unetTools.NewUpsampleLayer(3, 2)
+
+	// x feeds both branches of the concat, so its gradient must be accumulated
+	forward := func(tape *unetTools.Tape) *unetTools.Tensor {
+		resized := tape.Resize(x, 6, 7)
+		up := tape.Layer(upsample, x)
+		resizedUp := tape.Resize(up, 6, 7)
+		y := tape.Concat(tape.Activation(resized, "sigmoid"), resizedUp)
+		return tape.Loss(y, nil, sumOfSquares, sumOfSquaresGrad)
+	}
+
+	tape := unetTools.NewTape()
+	loss := forward(tape)
+	tape.Backward(loss)
+
+	checkGradient(t, "tape", func() float64 {
+		return forward(nil).Data[0]
+	}, x, tape.Grad(x))
+}
+
+func TestTapeBatchLossReductions(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := randomTensor(rng, 3, 1, 2, 2)
+	target := unetTools.NewTensorLike(x)
+	samples := []float64{
+		sumOfSquares(x.Sample(0), nil),
+		sumOfSquares(x.Sample(1), nil),
+		sumOfSquares(x.Sample(2), nil),
+	}
+	total := samples[0] + samples[1] + samples[2]
+
+	cases := map[string]struct {
+		value []float64
+		scale float64
+	}{
+		unetTools.LossReductionMean: {[]float64{total / 3}, 1.0 / 3},
+		unetTools.LossReductionSum:  {[]float64{total}, 1},
+		unetTools.LossReductionNone: {samples, 1},
+	}
+	for reduction, c := range cases {
+		tape := unetTools.NewTape()
+		loss := tape.BatchLoss(x, target, reduction, sumOfSquares, sumOfSquaresGrad)
+		if loss.Len() != len(c.value) {
+			t.Fatalf("%s: expected %d losses, but got %v", reduction, len(c.value), loss.Shape)
+		}
+		for i, v := range c.value {
+			if math.Abs(loss.Data[i]-v) > 1e-12 {
+				t.Errorf("%s: expected loss %d to be %v, but got %v", reduction, i, v, loss.Data[i])
+			}
+		}
+
+		tape.Backward(loss)
+		grad := tape.Grad(x)
+		for i, v := range x.Data {
+			if math.Abs(grad.Data[i]-v*c.scale) > 1e-12 {
+				t.Errorf("%s: expected gradient %v at %d, but got %v", reduction, v*c.scale, i, grad.Data[i])
+				break
+			}
+		}
+	}
+}
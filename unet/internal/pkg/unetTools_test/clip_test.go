@@ -0,0 +1,72 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestClipGradValue(t *testing.T) {
+	grad := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{-3, -0.5, 0.5, 3})
+
+	unetTools.ClipGradValue([]*unetTools.Tensor{grad}, 1)
+
+	want := []float64{-1, -0.5, 0.5, 1}
+	for i, w := range want {
+		if grad.Data[i] != w {
+			t.Errorf("Expected %v, but got %v", want, grad.Data)
+			break
+		}
+	}
+}
+
+func TestClipGradNorm(t *testing.T) {
+	a := unetTools.NewTensorFromData(1, 1, 1, 1, []float64{3})
+	b := unetTools.NewTensorFromData(1, 1, 1, 1, []float64{4})
+	grads := []*unetTools.Tensor{a, b}
+
+	// below the limit nothing changes
+	if norm := unetTools.ClipGradNorm(grads, 10); norm != 5 || a.Data[0] != 3 || b.Data[0] != 4 {
+		t.Errorf("Expected norm 5 and unchanged gradients, but got %v, %v and %v", norm, a.Data[0], b.Data[0])
+	}
+	// above it the gradients are scaled together
+	if norm := unetTools.ClipGradNorm(grads, 1); norm != 5 {
+		t.Errorf("Expected the pre-clip norm 5, but got %v", norm)
+	}
+	if math.Abs(a.Data[0]-0.6) > 1e-12 || math.Abs(b.Data[0]-0.8) > 1e-12 {
+		t.Errorf("Expected gradients [0.6 0.8], but got [%v %v]", a.Data[0], b.Data[0])
+	}
+	if norm := unetTools.GradNorm(grads); math.Abs(norm-1) > 1e-12 {
+		t.Errorf("Expected the clipped norm to be 1, but got %v", norm)
+	}
+}
+
+func TestUnetClipsGradNorm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const maxNorm = 1e-3
+	net := unetTools.NewUnet(16, 1, 1, 2, "sigmoid", 3, 2, 2, 1, unetTools.MSE{},
+		unetTools.WithOptimizer(unetTools.NewSGD()), unetTools.WithGradClipNorm(maxNorm))
+	input := randomTensor(rng, 1, 1, 16, 16)
+	var before []*unetTools.Tensor
+	for _, param := range net.Params() {
+		before = append(before, param.Clone())
+	}
+
+	net.Backward(net.Loss(net.Forward(input), input))
+
+	if net.GetGradNorm() <= maxNorm {
+		t.Fatalf("Expected a pre-clip gradient norm above %v, but got %v", maxNorm, net.GetGradNorm())
+	}
+	// with SGD and a learning rate of 1 the step is the clipped gradient
+	var steps []*unetTools.Tensor
+	for i, param := range net.Params() {
+		step := param.Clone()
+		step.Sub(before[i])
+		steps = append(steps, step)
+	}
+	if norm := unetTools.GradNorm(steps); math.Abs(norm-maxNorm) > 1e-12 {
+		t.Errorf("Expected an update of norm %v, but got %v", maxNorm, norm)
+	}
+}
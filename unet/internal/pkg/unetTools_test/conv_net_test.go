@@ -1,11 +1,11 @@
 package unetTools_test
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 
-	"unet-go/unet/internal/pkg/unetTools"
-
-	"github.com/gonum/matrix/mat64"
+	"unet/unet/internal/pkg/unetTools"
 )
 
 func TestNewConvLayer(t *testing.T) {
@@ -41,11 +41,14 @@ func TestConvLayerForward(t *testing.T) {
 
 	cl := unetTools.NewConvLayer(inputChannels, kernelSize, numFilters, activation)
 
-	input := mat64.NewDense(10, 10, nil)
+	input := unetTools.NewTensor(2, inputChannels, 10, 10)
 
-	_ = cl.Forward(input)
+	output := cl.Forward(input)
 
-	// Add assertions for the expected output
+	n, c, h, w := output.Dims()
+	if n != 2 || c != numFilters || h != 8 || w != 8 {
+		t.Errorf("Expected output shape 2x%dx8x8, but got %dx%dx%dx%d", numFilters, n, c, h, w)
+	}
 
 }
 
@@ -57,26 +60,194 @@ func TestConvLayerBackward(t *testing.T) {
 
 	cl := unetTools.NewConvLayer(inputChannels, kernelSize, numFilters, activation)
 
-	gradOutput := mat64.NewDense(10, 10, nil)
+	_ = cl.Forward(unetTools.NewTensor(1, inputChannels, 10, 10))
+	gradOutput := unetTools.NewTensor(1, numFilters, 8, 8)
 
-	_, _ = cl.Backward(gradOutput)
+	gradInput := cl.Backward(gradOutput)
 
-	// Add assertions for the expected gradInput and gradWeights
+	if gradInput.Shape != [4]int{1, inputChannels, 10, 10} {
+		t.Errorf("Expected gradInput shape [1 %d 10 10], but got %v", inputChannels, gradInput.Shape)
+	}
+	params, grads := cl.Params(), cl.Grads()
+	if len(params) != len(grads) {
+		t.Fatalf("Expected as many grads as params, but got %d and %d", len(grads), len(params))
+	}
+	for i := range params {
+		if !params[i].SameShape(grads[i]) {
+			t.Errorf("Expected grad %d to have shape %v, but got %v", i, params[i].Shape, grads[i].Shape)
+		}
+	}
 }
 
-func TestConvLayerUpdateWeightsAndBiases(t *testing.T) {
-	inputChannels := 3
-	kernelSize := 3
-	numFilters := 16
-	activation := "relu"
+func TestConvLayerBackwardGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cl := unetTools.NewConvLayer(2, 3, 3, "sigmoid")
+	input := randomTensor(rng, 2, 2, 6, 5)
 
-	cl := unetTools.NewConvLayer(inputChannels, kernelSize, numFilters, activation)
+	loss := func() float64 {
+		return sumOfSquares(cl.Forward(input), nil)
+	}
+
+	output := cl.Forward(input)
+	gradInput := cl.Backward(sumOfSquaresGrad(output, nil))
+	grads := cl.Grads()
+
+	checkGradient(t, "input", loss, input, gradInput)
+	checkGradient(t, "weights", loss, cl.Weights, grads[0])
+	checkGradient(t, "biases", loss, cl.Biases, grads[1])
+}
+
+func TestConvLayerKernelPerInputChannel(t *testing.T) {
+	cl := unetTools.NewConvLayer(2, 1, 1, "")
+	// the filter ignores channel 0 and doubles channel 1
+	cl.Weights.Data = []float64{0, 2}
+	cl.Biases.Data[0] = 0
+	input := unetTools.NewTensorFromData(1, 2, 1, 2, []float64{5, 7, 1, 3})
+
+	output := cl.Forward(input)
+
+	if output.At(0, 0, 0, 0) != 2 || output.At(0, 0, 0, 1) != 6 {
+		t.Errorf("Expected output [2 6], but got %v", output.Data)
+	}
+}
+
+func TestConvLayerWrongInputChannelsPanics(t *testing.T) {
+	cl := unetTools.NewConvLayer(3, 3, 2, "relu")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Forward with the wrong number of channels to panic")
+		}
+	}()
+	cl.Forward(unetTools.NewTensor(1, 2, 5, 5))
+}
+
+func TestConvLayerPaddingKeepsSize(t *testing.T) {
+	for _, padding := range []string{unetTools.PaddingSame, unetTools.PaddingReflect, unetTools.PaddingReplicate} {
+		for _, kernelSize := range []int{1, 2, 3, 4} {
+			cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+				Activation: "relu", InputChannels: 2, KernelSize: kernelSize, NumFilters: 3, Padding: padding,
+			})
 
-	learningRate := 0.001
-	gradWeights := mat64.NewDense(10, 10, nil)
-	gradBiases := mat64.NewDense(1, numFilters, nil)
+			output := cl.Forward(unetTools.NewTensor(1, 2, 7, 6))
 
-	cl.UpdateWeightsAndBiases(learningRate, gradWeights, gradBiases)
+			if output.Shape != [4]int{1, 3, 7, 6} {
+				t.Errorf("%s padding with kernel %d: expected output shape [1 3 7 6], but got %v", padding, kernelSize, output.Shape)
+			}
+		}
+	}
+}
+
+func TestConvLayerPaddingModes(t *testing.T) {
+	// a 3x1 kernel that picks the element to the left of the centre shows the padded border
+	input := unetTools.NewTensorFromData(1, 1, 1, 3, []float64{1, 2, 3})
+	expected := map[string][]float64{
+		unetTools.PaddingSame:      {0, 1, 2},
+		unetTools.PaddingReflect:   {2, 1, 2},
+		unetTools.PaddingReplicate: {1, 1, 2},
+	}
+	for padding, want := range expected {
+		cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			InputChannels: 1, KernelSize: 3, NumFilters: 1, Padding: padding,
+		})
+		// Convolve normalizes by the kernel area
+		cl.Weights.Zero()
+		cl.Weights.Set(0, 0, 1, 0, 9)
+		cl.Biases.Zero()
+
+		output := cl.Forward(input)
+
+		for i := range want {
+			if output.Data[i] != want[i] {
+				t.Errorf("%s padding: expected %v, but got %v", padding, want, output.Data)
+				break
+			}
+		}
+	}
+}
+
+func TestConvLayerPaddingBackwardGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, padding := range []string{unetTools.PaddingSame, unetTools.PaddingReflect, unetTools.PaddingReplicate} {
+		cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			Activation: "sigmoid", InputChannels: 2, KernelSize: 3, NumFilters: 2, Padding: padding,
+		})
+		input := randomTensor(rng, 1, 2, 5, 4)
+
+		loss := func() float64 {
+			return sumOfSquares(cl.Forward(input), nil)
+		}
+
+		output := cl.Forward(input)
+		gradInput := cl.Backward(sumOfSquaresGrad(output, nil))
+
+		checkGradient(t, padding+" input", loss, input, gradInput)
+		checkGradient(t, padding+" weights", loss, cl.Weights, cl.Grads()[0])
+	}
+}
+
+func TestConvLayerStrideAndDilationShape(t *testing.T) {
+	cases := []struct {
+		padding          string
+		stride, dilation int
+		expected         [4]int
+	}{
+		{unetTools.PaddingValid, 2, 1, [4]int{1, 2, 4, 3}},
+		{unetTools.PaddingValid, 1, 2, [4]int{1, 2, 5, 3}},
+		{unetTools.PaddingSame, 2, 1, [4]int{1, 2, 5, 4}},
+		{unetTools.PaddingSame, 1, 2, [4]int{1, 2, 9, 7}},
+		{unetTools.PaddingReflect, 2, 2, [4]int{1, 2, 5, 4}},
+	}
+	input := unetTools.NewTensor(1, 1, 9, 7)
+	for _, c := range cases {
+		cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			InputChannels: 1, KernelSize: 3, NumFilters: 2,
+			Padding: c.padding, Stride: c.stride, Dilation: c.dilation,
+		})
+		output := cl.Forward(input)
+		if output.Shape != c.expected {
+			t.Errorf("%s padding, stride %d, dilation %d: expected output shape %v, but got %v",
+				c.padding, c.stride, c.dilation, c.expected, output.Shape)
+		}
+	}
+}
+
+func TestConvLayerDilationSkipsInputs(t *testing.T) {
+	// a dilated 2x2 kernel of ones reads the corners of a 3x3 window
+	input := unetTools.NewTensorFromData(1, 1, 3, 3, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+		InputChannels: 1, KernelSize: 2, NumFilters: 1, Dilation: 2,
+	})
+	cl.Weights.Apply(func(float64) float64 { return 4 })
+	cl.Biases.Zero()
 
-	// Add assertions for the updated Weights and biases
+	output := cl.Forward(input)
+
+	if output.Len() != 1 || output.Data[0] != 1+3+7+9 {
+		t.Errorf("expected a single output of %d, but got %v", 1+3+7+9, output.Data)
+	}
+}
+
+func TestConvLayerStrideAndDilationBackwardGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, padding := range []string{unetTools.PaddingValid, unetTools.PaddingSame, unetTools.PaddingReflect} {
+		for _, sd := range [][2]int{{2, 1}, {1, 2}, {2, 2}} {
+			cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+				Activation: "sigmoid", InputChannels: 2, KernelSize: 3, NumFilters: 2,
+				Padding: padding, Stride: sd[0], Dilation: sd[1],
+			})
+			input := randomTensor(rng, 1, 2, 8, 7)
+
+			loss := func() float64 {
+				return sumOfSquares(cl.Forward(input), nil)
+			}
+
+			output := cl.Forward(input)
+			gradInput := cl.Backward(sumOfSquaresGrad(output, nil))
+
+			name := fmt.Sprintf("%s stride %d dilation %d", padding, sd[0], sd[1])
+			checkGradient(t, name+" input", loss, input, gradInput)
+			checkGradient(t, name+" weights", loss, cl.Weights, cl.Grads()[0])
+			checkGradient(t, name+" biases", loss, cl.Biases, cl.Grads()[1])
+		}
+	}
 }
@@ -0,0 +1,79 @@
+package unetTools_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestConvTransLayerForward(t *testing.T) {
+	ctl := unetTools.NewConvTransLayer(3, 2, 2, 4, "relu")
+
+	output := ctl.Forward(unetTools.NewTensor(2, 3, 5, 6))
+
+	if output.Shape != [4]int{2, 4, 10, 12} {
+		t.Errorf("Expected output shape [2 4 10 12], but got %v", output.Shape)
+	}
+}
+
+func TestConvTransLayerBiasAddedOncePerPixel(t *testing.T) {
+	// with overlapping kernels and a zero input, every pixel must equal the bias
+	ctl := unetTools.NewConvTransLayer(1, 3, 1, 1, "")
+	ctl.Biases.Data[0] = 0.25
+
+	output := ctl.Forward(unetTools.NewTensor(1, 1, 4, 4))
+
+	for i, v := range output.Data {
+		if v != 0.25 {
+			t.Fatalf("Expected every output pixel to be 0.25, but pixel %d is %f", i, v)
+		}
+	}
+}
+
+func TestConvTransLayerBackwardGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ctl := unetTools.NewConvTransLayer(2, 3, 2, 3, "sigmoid")
+	input := randomTensor(rng, 2, 2, 3, 4)
+
+	loss := func() float64 {
+		return sumOfSquares(ctl.Forward(input), nil)
+	}
+
+	output := ctl.Forward(input)
+	gradInput := ctl.Backward(sumOfSquaresGrad(output, nil))
+	grads := ctl.Grads()
+
+	checkGradient(t, "input", loss, input, gradInput)
+	checkGradient(t, "weights", loss, ctl.Weights, grads[0])
+	checkGradient(t, "biases", loss, ctl.Biases, grads[1])
+}
+
+func TestConvTransLayerZeroStrideMeansOne(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	ctl := unetTools.NewConvTransLayerFromParams(unetTools.ConvTransParams{
+		Activation:    "sigmoid",
+		InputChannels: 2,
+		KernelSize:    3,
+		NumFilters:    3,
+	})
+	input := randomTensor(rng, 1, 2, 3, 4)
+
+	output := ctl.Forward(input)
+	if ctl.Stride != 1 || output.Shape != [4]int{1, 3, 5, 6} {
+		t.Fatalf("Expected stride 1 and output shape [1 3 5 6], but got stride %d and shape %v", ctl.Stride, output.Shape)
+	}
+	loss := func() float64 {
+		return sumOfSquares(ctl.Forward(input), nil)
+	}
+	checkGradient(t, "input", loss, input, ctl.Backward(sumOfSquaresGrad(output, nil)))
+}
+
+func TestConvTransLayerNegativeStridePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a negative stride to panic")
+		}
+	}()
+	unetTools.NewConvTransLayerFromParams(unetTools.ConvTransParams{InputChannels: 1, KernelSize: 2, Stride: -1, NumFilters: 1})
+}
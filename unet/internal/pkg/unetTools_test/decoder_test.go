@@ -0,0 +1,38 @@
+package unetTools_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestDecoderBackwardRoutesSkipGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	enc := unetTools.NewEncoder(
+		[]unetTools.ConvParams{{Activation: "sigmoid", InputChannels: 1, KernelSize: 3, NumFilters: 2}},
+		[]unetTools.PoolParams{{PoolSize: 2, Stride: 2}},
+	)
+	dec := unetTools.NewDecoder(
+		[]unetTools.ConvParams{{Activation: "sigmoid", InputChannels: 4, KernelSize: 3, NumFilters: 1}},
+		[]unetTools.ConvTransParams{{Activation: "sigmoid", InputChannels: 2, KernelSize: 2, Stride: 2, NumFilters: 2}},
+	)
+	input := randomTensor(rng, 2, 1, 11, 11)
+
+	loss := func() float64 {
+		output, skip := enc.ForwardWithSkip(input)
+		return sumOfSquares(dec.Forward(output, skip), nil)
+	}
+
+	output, skip := enc.ForwardWithSkip(input)
+	decoded := dec.Forward(output, skip)
+	gradInput, gradSkip := dec.Backward(sumOfSquaresGrad(decoded, nil))
+	if gradSkip == nil {
+		t.Fatalf("Expected a gradient for the skip features")
+	}
+	if !gradSkip.SameShape(skip) {
+		t.Fatalf("Expected skip gradient shape %v, but got %v", skip.Shape, gradSkip.Shape)
+	}
+
+	checkGradient(t, "input", loss, input, enc.BackwardWithSkip(gradInput, gradSkip))
+}
@@ -0,0 +1,76 @@
+package unetTools_test
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// trainDeterministic trains a small model for two epochs on samples, shuffling
+// and augmenting them from the model's own random streams, and returns its parameters
+func trainDeterministic(seed int64, workers int, samples []*unetTools.Tensor) []*unetTools.Tensor {
+	unetTools.SetNumWorkers(workers)
+	defer unetTools.SetNumWorkers(0)
+
+	net := unetTools.NewUnet(16, 1, 2, 2, "sigmoid", 3, 2, 2, 0.01, unetTools.MSE{},
+		unetTools.WithDeterministic(seed),
+		unetTools.WithScheduler(unetTools.NewCosineWarmRestarts(2, 2, 0)),
+		unetTools.WithGradClipNorm(1))
+	for epoch := 0; epoch < 2; epoch++ {
+		order := net.Shuffle(len(samples))
+		for i := 0; i+1 < len(order); i += 2 {
+			batch := unetTools.StackSamples(samples[order[i]], samples[order[i+1]])
+			// augment with a little noise
+			noisy := batch.Clone()
+			augment := net.Rand(unetTools.RandAugment)
+			noisy.Apply(func(v float64) float64 { return v + 0.01*augment.NormFloat64() })
+
+			net.Backward(net.Loss(net.Forward(noisy), batch))
+		}
+	}
+	return net.Params()
+}
+
+func TestDeterministicTrainingIsBitIdentical(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var samples []*unetTools.Tensor
+	for i := 0; i < 6; i++ {
+		samples = append(samples, randomTensor(rng, 1, 1, 16, 16))
+	}
+
+	first := trainDeterministic(3, 1, samples)
+	second := trainDeterministic(3, 4, samples)
+	other := trainDeterministic(4, 1, samples)
+
+	for i := range first {
+		if !slices.Equal(first[i].Data, second[i].Data) {
+			t.Fatalf("parameter %d differs between two deterministic runs with the same seed", i)
+		}
+	}
+	same := true
+	for i := range first {
+		same = same && slices.Equal(first[i].Data, other[i].Data)
+	}
+	if same {
+		t.Errorf("Expected runs with different seeds to differ")
+	}
+}
+
+func TestUnetRandStreams(t *testing.T) {
+	a := unetTools.NewUnet(16, 1, 1, 2, "relu", 3, 2, 2, 0.001, unetTools.MSE{}, unetTools.WithDeterministic(5))
+	b := unetTools.NewUnet(16, 1, 1, 2, "relu", 3, 2, 2, 0.001, unetTools.MSE{}, unetTools.WithDeterministic(5))
+
+	if !a.Deterministic() {
+		t.Errorf("Expected the model to be deterministic")
+	}
+	if a.Rand(unetTools.RandDropout) != a.Rand(unetTools.RandDropout) {
+		t.Errorf("Expected Rand to return the same source for the same stream")
+	}
+	// drawing from one stream does not shift another
+	a.Rand(unetTools.RandAugment).Float64()
+	if !slices.Equal(a.Shuffle(10), b.Shuffle(10)) {
+		t.Errorf("Expected the same seed to give the same shuffles")
+	}
+}
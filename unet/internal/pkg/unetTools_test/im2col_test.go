@@ -0,0 +1,102 @@
+package unetTools_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// convLayerPair returns a naive and a gemm ConvLayer sharing the same parameters
+func convLayerPair(params unetTools.ConvParams) (*unetTools.ConvLayer, *unetTools.ConvLayer) {
+	params.Backend = unetTools.ConvBackendNaive
+	naive := unetTools.NewConvLayerFromParams(params)
+	params.Backend = unetTools.ConvBackendGemm
+	gemm := unetTools.NewConvLayerFromParams(params)
+	copy(gemm.Weights.Data, naive.Weights.Data)
+	copy(gemm.Biases.Data, naive.Biases.Data)
+	return naive, gemm
+}
+
+func assertTensorsClose(t *testing.T, name string, want, got *unetTools.Tensor) {
+	t.Helper()
+	if want.Shape != got.Shape {
+		t.Errorf("%s: expected shape %v, but got %v", name, want.Shape, got.Shape)
+		return
+	}
+	for i := range want.Data {
+		if math.Abs(want.Data[i]-got.Data[i]) > 1e-12*math.Max(1, math.Abs(want.Data[i])) {
+			t.Errorf("%s: element %d differs, expected %v, but got %v", name, i, want.Data[i], got.Data[i])
+			return
+		}
+	}
+}
+
+func TestConvBackendsAgree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, padding := range []string{unetTools.PaddingValid, unetTools.PaddingSame, unetTools.PaddingReflect} {
+		for _, sd := range [][2]int{{1, 1}, {2, 1}, {1, 2}, {2, 2}} {
+			naive, gemm := convLayerPair(unetTools.ConvParams{
+				Activation: "sigmoid", InputChannels: 3, KernelSize: 3, NumFilters: 4,
+				Padding: padding, Stride: sd[0], Dilation: sd[1],
+			})
+			input := randomTensor(rng, 2, 3, 9, 8)
+			name := fmt.Sprintf("%s stride %d dilation %d", padding, sd[0], sd[1])
+
+			output := naive.Forward(input)
+			assertTensorsClose(t, name+" output", output, gemm.Forward(input))
+
+			gradOutput := randomTensor(rng, output.Shape[0], output.Shape[1], output.Shape[2], output.Shape[3])
+			assertTensorsClose(t, name+" input gradient", naive.Backward(gradOutput), gemm.Backward(gradOutput))
+			assertTensorsClose(t, name+" weight gradient", naive.Grads()[0], gemm.Grads()[0])
+			assertTensorsClose(t, name+" bias gradient", naive.Grads()[1], gemm.Grads()[1])
+		}
+	}
+}
+
+func TestConvLayerUnknownBackendPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected NewConvLayerFromParams to panic on an unknown backend")
+		}
+	}()
+	unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+		InputChannels: 1, KernelSize: 3, NumFilters: 1, Backend: "fft",
+	})
+}
+
+func benchmarkConvLayer(b *testing.B, backend string, backward bool) {
+	rng := rand.New(rand.NewSource(1))
+	cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+		Activation: "relu", InputChannels: 16, KernelSize: 3, NumFilters: 16,
+		Padding: unetTools.PaddingSame, Backend: backend,
+	})
+	input := randomTensor(rng, 1, 16, 64, 64)
+	output := cl.Forward(input)
+	gradOutput := randomTensor(rng, output.Shape[0], output.Shape[1], output.Shape[2], output.Shape[3])
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cl.Forward(input)
+		if backward {
+			cl.Backward(gradOutput)
+		}
+	}
+}
+
+func BenchmarkConvLayerForwardNaive(b *testing.B) {
+	benchmarkConvLayer(b, unetTools.ConvBackendNaive, false)
+}
+
+func BenchmarkConvLayerForwardGemm(b *testing.B) {
+	benchmarkConvLayer(b, unetTools.ConvBackendGemm, false)
+}
+
+func BenchmarkConvLayerForwardBackwardNaive(b *testing.B) {
+	benchmarkConvLayer(b, unetTools.ConvBackendNaive, true)
+}
+
+func BenchmarkConvLayerForwardBackwardGemm(b *testing.B) {
+	benchmarkConvLayer(b, unetTools.ConvBackendGemm, true)
+}
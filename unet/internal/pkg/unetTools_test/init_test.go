@@ -0,0 +1,132 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// meanAndStd returns the mean and standard deviation of values
+func meanAndStd(values []float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+func TestInitializerStatistics(t *testing.T) {
+	// 64 filters over 32 channels with 3x3 kernels
+	fanIn, fanOut := 32*9.0, 64*9.0
+	cases := map[string]struct {
+		std   float64
+		limit float64 // bound on the absolute values, 0 for none
+	}{
+		unetTools.InitHeNormal:      {math.Sqrt(2 / fanIn), 0},
+		unetTools.InitHeUniform:     {math.Sqrt(2 / fanIn), math.Sqrt(6 / fanIn)},
+		unetTools.InitXavierNormal:  {math.Sqrt(2 / (fanIn + fanOut)), 0},
+		unetTools.InitXavierUniform: {math.Sqrt(2 / (fanIn + fanOut)), math.Sqrt(6 / (fanIn + fanOut))},
+	}
+	for init, c := range cases {
+		cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			InputChannels: 32, KernelSize: 3, NumFilters: 64,
+			WeightInit: init, BiasInit: unetTools.InitZeros, Rand: rand.New(rand.NewSource(1)),
+		})
+		mean, std := meanAndStd(cl.Weights.Data)
+		if math.Abs(mean) > 0.05*c.std || math.Abs(std-c.std) > 0.05*c.std {
+			t.Errorf("%s: expected mean 0 and deviation %v, but got %v and %v", init, c.std, mean, std)
+		}
+		for _, v := range cl.Weights.Data {
+			if c.limit > 0 && math.Abs(v) > c.limit {
+				t.Errorf("%s: expected values within %v, but got %v", init, c.limit, v)
+				break
+			}
+		}
+		for _, v := range cl.Biases.Data {
+			if v != 0 {
+				t.Errorf("%s: expected zero biases, but got %v", init, cl.Biases.Data)
+				break
+			}
+		}
+	}
+}
+
+func TestOrthogonalInitializer(t *testing.T) {
+	// 4 filters of 2x3x3 have orthonormal rows, 12 filters of 1x2x2 orthonormal columns
+	for _, shape := range [][3]int{{4, 2, 3}, {12, 1, 2}} {
+		cl := unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			NumFilters: shape[0], InputChannels: shape[1], KernelSize: shape[2],
+			WeightInit: unetTools.InitOrthogonal, Rand: rand.New(rand.NewSource(1)),
+		})
+		rows := shape[0]
+		cols := cl.Weights.Len() / rows
+		at := func(i, j int) float64 { return cl.Weights.Data[i*cols+j] }
+		if rows > cols {
+			rows, cols = cols, rows
+			at = func(i, j int) float64 { return cl.Weights.Data[j*rows+i] }
+		}
+		for i := 0; i < rows; i++ {
+			for j := 0; j < rows; j++ {
+				dot := 0.0
+				for k := 0; k < cols; k++ {
+					dot += at(i, k) * at(j, k)
+				}
+				want := 0.0
+				if i == j {
+					want = 1
+				}
+				if math.Abs(dot-want) > 1e-12 {
+					t.Errorf("%v: expected dot product %v of vectors %d and %d, but got %v", shape, want, i, j, dot)
+				}
+			}
+		}
+	}
+}
+
+func TestInitializerUsesRand(t *testing.T) {
+	params := unetTools.ConvParams{InputChannels: 2, KernelSize: 3, NumFilters: 3, WeightInit: unetTools.InitHeNormal}
+	params.Rand = rand.New(rand.NewSource(7))
+	a := unetTools.NewConvLayerFromParams(params)
+	params.Rand = rand.New(rand.NewSource(7))
+	b := unetTools.NewConvLayerFromParams(params)
+	params.Rand = rand.New(rand.NewSource(8))
+	c := unetTools.NewConvLayerFromParams(params)
+
+	if !slices.Equal(a.Weights.Data, b.Weights.Data) {
+		t.Errorf("Expected the same seed to give the same weights")
+	}
+	if slices.Equal(a.Weights.Data, c.Weights.Data) {
+		t.Errorf("Expected different seeds to give different weights")
+	}
+}
+
+func TestUnknownInitializerPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected NewConvLayerFromParams to panic on an unknown initializer")
+		}
+	}()
+	unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+		InputChannels: 1, KernelSize: 3, NumFilters: 1, WeightInit: "lecun",
+	})
+}
+
+func TestUnetWithSeedIsReproducible(t *testing.T) {
+	a := unetTools.NewUnet(16, 1, 2, 2, "relu", 3, 2, 2, 0.001, unetTools.MSE{}, unetTools.WithSeed(42))
+	b := unetTools.NewUnet(16, 1, 2, 2, "relu", 3, 2, 2, 0.001, unetTools.MSE{}, unetTools.WithSeed(42))
+
+	paramsA, paramsB := a.Params(), b.Params()
+	for i := range paramsA {
+		if !slices.Equal(paramsA[i].Data, paramsB[i].Data) {
+			t.Fatalf("parameter %d differs between two models with the same seed", i)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// randomProbabilities returns a Tensor of values in [0.05, 0.95)
+func randomProbabilities(rng *rand.Rand, n, c, h, w int) *unetTools.Tensor {
+	t := unetTools.NewTensor(n, c, h, w)
+	for i := range t.Data {
+		t.Data[i] = 0.05 + 0.9*rng.Float64()
+	}
+	return t
+}
+
+// randomMask returns a Tensor of zeros and ones
+func randomMask(rng *rand.Rand, n, c, h, w int) *unetTools.Tensor {
+	t := unetTools.NewTensor(n, c, h, w)
+	for i := range t.Data {
+		t.Data[i] = float64(rng.Intn(2))
+	}
+	return t
+}
+
+func TestLossGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	losses := []struct {
+		name string
+		loss unetTools.Loss
+	}{
+		{"mse", unetTools.MSE{}},
+		{"dice", unetTools.NewDice(1)},
+		{"generalized dice", unetTools.NewGeneralizedDice(nil, 1e-3)},
+		{"weighted dice", unetTools.NewGeneralizedDice([]float64{0.2, 0.8}, 1)},
+		{"tversky", unetTools.NewTversky(0.3, 0.7, 1)},
+		{"focal tversky", unetTools.NewFocalTversky(0.3, 0.7, 0.75, 1)},
+		{"bce", unetTools.BinaryCrossEntropy{}},
+		{"weighted bce", unetTools.NewBinaryCrossEntropy(false, unetTools.ClassOptions{Weights: []float64{0.3, 2}})},
+		{"focal", unetTools.NewFocalLoss(2, 0.25, false, unetTools.ClassOptions{})},
+		{"focal gamma 0.5", unetTools.NewFocalLoss(0.5, 0.75, false, unetTools.ClassOptions{Weights: []float64{1, 3}})},
+		{"composite", unetTools.NewCompositeLoss(
+			unetTools.LossTerm{Name: "bce", Loss: unetTools.BinaryCrossEntropy{}, Weight: 0.5},
+			unetTools.LossTerm{Name: "dice", Loss: unetTools.NewDice(1), Weight: 0.5},
+		)},
+	}
+	for _, c := range losses {
+		pred := randomProbabilities(rng, 1, 2, 4, 4)
+		target := randomMask(rng, 1, 2, 4, 4)
+		checkGradient(t, c.name, func() float64 { return c.loss.Value(pred, target) }, pred, c.loss.Grad(pred, target))
+	}
+}
+
+func TestLossFromLogitsGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	losses := []struct {
+		name string
+		loss unetTools.Loss
+	}{
+		{"bce", unetTools.NewBinaryCrossEntropy(true, unetTools.ClassOptions{Weights: []float64{1, 4}})},
+		{"focal", unetTools.NewFocalLoss(2, 0.25, true, unetTools.ClassOptions{})},
+	}
+	for _, c := range losses {
+		logits := randomTensor(rng, 1, 2, 4, 4)
+		logits.Scale(4)
+		target := randomMask(rng, 1, 2, 4, 4)
+		checkGradient(t, c.name, func() float64 { return c.loss.Value(logits, target) }, logits, c.loss.Grad(logits, target))
+	}
+}
+
+// randomDistributions returns a Tensor holding a probability distribution
+// over the channels at every pixel, and a one-hot target
+func randomDistributions(rng *rand.Rand, c, h, w int) (*unetTools.Tensor, *unetTools.Tensor) {
+	pred := randomProbabilities(rng, 1, c, h, w)
+	target := unetTools.NewTensor(1, c, h, w)
+	for i := 0; i < h*w; i++ {
+		sum := 0.0
+		for k := 0; k < c; k++ {
+			sum += pred.Data[k*h*w+i]
+		}
+		for k := 0; k < c; k++ {
+			pred.Data[k*h*w+i] /= sum
+		}
+		target.Data[rng.Intn(c)*h*w+i] = 1
+	}
+	return pred, target
+}
+
+func TestCategoricalCrossEntropy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pred, target := randomDistributions(rng, 3, 4, 4)
+	options := unetTools.ClassOptions{Weights: []float64{0.5, 1, 2}, Ignore: true, IgnoreIndex: 2}
+	loss := unetTools.NewCategoricalCrossEntropy(options)
+	checkGradient(t, "categorical cross-entropy", func() float64 { return loss.Value(pred, target) }, pred, loss.Grad(pred, target))
+
+	// the weighted mean of -log p of the class of every pixel, leaving class 2 out
+	sum, total := 0.0, 0.0
+	for i := 0; i < 16; i++ {
+		for c := 0; c < 2; c++ {
+			if target.Data[c*16+i] == 1 {
+				sum -= options.Weights[c] * math.Log(pred.Data[c*16+i])
+				total += options.Weights[c]
+			}
+		}
+	}
+	if got := loss.Value(pred, target); math.Abs(got-sum/total) > 1e-12 {
+		t.Errorf("Expected categorical cross-entropy %g, but got %g", sum/total, got)
+	}
+}
+
+func TestCategoricalCrossEntropyIgnoresUnlabelledPixels(t *testing.T) {
+	// 255 marks an unlabelled pixel, which OneHot leaves all zeros
+	target := unetTools.OneHot(unetTools.NewTensorFromData(1, 1, 1, 2, []float64{1, 255}), 2)
+	pred := unetTools.NewTensorFromData(1, 2, 1, 2, []float64{0.5, 0.5, 0.5, 0.5})
+
+	options := map[string]unetTools.ClassOptions{
+		"no options":   {},
+		"ignore index": {Ignore: true, IgnoreIndex: 255},
+		"weights":      {Weights: []float64{5, 1}},
+	}
+	for name, option := range options {
+		loss := unetTools.NewCategoricalCrossEntropy(option)
+		if got := loss.Value(pred, target); math.Abs(got-math.Ln2) > 1e-12 {
+			t.Errorf("%s: expected only the labelled pixel to count (%g), but got %g", name, math.Ln2, got)
+		}
+		if grad := loss.Grad(pred, target); grad.Data[1] != 0 || grad.Data[3] != 0 {
+			t.Errorf("%s: expected no gradient on the unlabelled pixel, but got %v", name, grad.Data)
+		}
+	}
+}
+
+func TestBinaryCrossEntropyFromLogits(t *testing.T) {
+	logits := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{-2, 0.5, 3, 1000})
+	target := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{0, 1, 1, 0})
+
+	want := 0.0
+	for i, z := range logits.Data[:3] {
+		p := 1 / (1 + math.Exp(-z))
+		want -= target.Data[i]*math.Log(p) + (1-target.Data[i])*math.Log(1-p)
+	}
+	// log(1 + e^1000) would overflow if it were computed as written
+	want = (want + 1000) / 4
+
+	loss := unetTools.NewBinaryCrossEntropy(true, unetTools.ClassOptions{})
+	if got := loss.Value(logits, target); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Expected binary cross-entropy from logits %g, but got %g", want, got)
+	}
+	if grad := loss.Grad(logits, target); math.Abs(grad.Data[3]-0.25) > 1e-12 {
+		t.Errorf("Expected the gradient of a saturated logit to be 0.25, but got %g", grad.Data[3])
+	}
+}
+
+func TestFocalLossReducesToCrossEntropy(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	pred := randomProbabilities(rng, 1, 1, 4, 4)
+	target := randomMask(rng, 1, 1, 4, 4)
+
+	bce := unetTools.BinaryCrossEntropy{}.Value(pred, target)
+	focal := unetTools.NewFocalLoss(0, 0.5, false, unetTools.ClassOptions{}).Value(pred, target)
+	if math.Abs(focal-bce/2) > 1e-12 {
+		t.Errorf("Expected focal loss with gamma 0 and alpha 0.5 to be %g, but got %g", bce/2, focal)
+	}
+	if sharp := unetTools.NewFocalLoss(2, 0.5, false, unetTools.ClassOptions{}).Value(pred, target); sharp >= focal {
+		t.Errorf("Expected gamma 2 to shrink the focal loss below %g, but got %g", focal, sharp)
+	}
+}
+
+func TestLossGradientsAtSaturatedProbabilities(t *testing.T) {
+	binaryPred := unetTools.NewTensorFromData(1, 1, 1, 5, []float64{0, 1, 0, 1, 0.3})
+	binaryTarget := unetTools.NewTensorFromData(1, 1, 1, 5, []float64{0, 0, 1, 1, 1})
+	categoricalPred := unetTools.NewTensorFromData(1, 2, 1, 3, []float64{0, 1, 0.4, 1, 0, 0.6})
+	categoricalTarget := unetTools.OneHot(unetTools.NewTensorFromData(1, 1, 1, 3, []float64{0, 0, 1}), 2)
+
+	cases := []struct {
+		name         string
+		loss         unetTools.Loss
+		pred, target *unetTools.Tensor
+	}{
+		{"bce", unetTools.BinaryCrossEntropy{}, binaryPred, binaryTarget},
+		{"focal", unetTools.NewFocalLoss(2, 0.25, false, unetTools.ClassOptions{}), binaryPred, binaryTarget},
+		{"categorical cross-entropy", unetTools.CategoricalCrossEntropy{}, categoricalPred, categoricalTarget},
+	}
+	for _, c := range cases {
+		// a step well inside the clamp, so that the numeric gradient sees
+		// the loss the way Value computes it
+		const h = 1e-9
+		analytic := c.loss.Grad(c.pred, c.target)
+		for i := range c.pred.Data {
+			orig := c.pred.Data[i]
+			c.pred.Data[i] = orig + h
+			plus := c.loss.Value(c.pred, c.target)
+			c.pred.Data[i] = orig - h
+			minus := c.loss.Value(c.pred, c.target)
+			c.pred.Data[i] = orig
+			numeric := (plus - minus) / (2 * h)
+			if math.Abs(numeric-analytic.Data[i]) > 1e-5*math.Max(1, math.Abs(numeric)) {
+				t.Errorf("%s: gradient mismatch at %d (p = %g): analytic %g, numeric %g", c.name, i, orig, analytic.Data[i], numeric)
+			}
+		}
+	}
+}
+
+func TestBinaryLossesIgnoreIndex(t *testing.T) {
+	pred := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{0.9, 0.2, 0.7, 0.01})
+	target := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{1, 0, 255, 255})
+	options := unetTools.ClassOptions{Ignore: true, IgnoreIndex: 255}
+	labelled := unetTools.NewTensorFromData(1, 1, 1, 2, []float64{0.9, 0.2})
+	labels := unetTools.NewTensorFromData(1, 1, 1, 2, []float64{1, 0})
+
+	losses := map[string][2]unetTools.Loss{
+		"bce":   {unetTools.NewBinaryCrossEntropy(false, options), unetTools.BinaryCrossEntropy{}},
+		"focal": {unetTools.NewFocalLoss(2, 0.25, false, options), unetTools.NewFocalLoss(2, 0.25, false, unetTools.ClassOptions{})},
+	}
+	for name, pair := range losses {
+		if got, want := pair[0].Value(pred, target), pair[1].Value(labelled, labels); math.Abs(got-want) > 1e-12 {
+			t.Errorf("%s: expected the ignored elements to be left out (%g), but got %g", name, want, got)
+		}
+		grad := pair[0].Grad(pred, target)
+		if grad.Data[2] != 0 || grad.Data[3] != 0 {
+			t.Errorf("%s: expected no gradient on the ignored elements, but got %v", name, grad.Data[2:])
+		}
+	}
+}
+
+func TestLossValues(t *testing.T) {
+	pred := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{1, 0, 0.5, 0.5})
+	target := unetTools.NewTensorFromData(1, 1, 1, 4, []float64{1, 1, 0, 1})
+
+	if got := (unetTools.MSE{}).Value(pred, target); math.Abs(got-0.375) > 1e-12 {
+		t.Errorf("Expected MSE 0.375, but got %g", got)
+	}
+	// 1 - (2 * 1.5) / (2 + 3)
+	if got := unetTools.NewDice(0).Value(pred, target); math.Abs(got-0.4) > 1e-12 {
+		t.Errorf("Expected Dice loss 0.4, but got %g", got)
+	}
+	empty := unetTools.NewTensor(1, 1, 2, 2)
+	if got := unetTools.NewDice(1).Value(empty, empty); got != 0 {
+		t.Errorf("Expected Dice loss 0 on empty masks, but got %g", got)
+	}
+}
+
+func TestDiceVariantsAgree(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	pred := randomProbabilities(rng, 1, 1, 4, 4)
+	target := randomMask(rng, 1, 1, 4, 4)
+	dice := unetTools.NewDice(2).Value(pred, target)
+
+	// Tversky halves the Dice numerator and denominator, smoothing included
+	variants := map[string]unetTools.Loss{
+		"generalized dice with one class": unetTools.NewGeneralizedDice([]float64{1}, 2),
+		"tversky with alpha = beta = 0.5": unetTools.NewTversky(0.5, 0.5, 1),
+		"focal tversky with gamma = 1":    unetTools.NewFocalTversky(0.5, 0.5, 1, 1),
+	}
+	for name, loss := range variants {
+		if got := loss.Value(pred, target); math.Abs(got-dice) > 1e-12 {
+			t.Errorf("%s: expected the Dice loss %g, but got %g", name, dice, got)
+		}
+	}
+}
+
+func TestGeneralizedDiceWeighsClassesBySize(t *testing.T) {
+	// the small class is missed entirely, the large one is found
+	pred := unetTools.NewTensorFromData(1, 2, 1, 4, []float64{0, 0, 0, 0, 1, 1, 1, 0})
+	target := unetTools.NewTensorFromData(1, 2, 1, 4, []float64{1, 0, 0, 0, 1, 1, 1, 0})
+
+	generalized := unetTools.NewGeneralizedDice(nil, 0).Value(pred, target)
+	uniform := unetTools.NewGeneralizedDice([]float64{1, 1}, 0).Value(pred, target)
+	if generalized <= uniform {
+		t.Errorf("Expected the missed small class to weigh more than with uniform weights, but got %g <= %g", generalized, uniform)
+	}
+}
+
+func TestGeneralizedDiceEmptyTarget(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	pred := randomProbabilities(rng, 1, 2, 4, 4)
+	target := unetTools.NewTensor(1, 2, 4, 4)
+	loss := unetTools.NewGeneralizedDice(nil, 0)
+
+	if got := loss.Value(pred, target); got != 0 {
+		t.Errorf("Expected a loss of 0 on an all-background target, but got %g", got)
+	}
+	for i, g := range loss.Grad(pred, target).Data {
+		if g != 0 {
+			t.Fatalf("Expected a zero gradient on an all-background target, but got %g at %d", g, i)
+		}
+	}
+}
+
+func TestGeneralizedDiceBatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected GeneralizedDice on two samples at once to panic")
+		}
+	}()
+	unetTools.NewGeneralizedDice(nil, 1).Value(unetTools.NewTensor(2, 2, 4, 4), unetTools.NewTensor(2, 2, 4, 4))
+}
+
+func TestCompositeLossIsWeightedSum(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	pred := randomProbabilities(rng, 1, 1, 4, 4)
+	target := randomMask(rng, 1, 1, 4, 4)
+	bce, dice := unetTools.BinaryCrossEntropy{}, unetTools.NewDice(1)
+	loss := unetTools.NewCompositeLoss(
+		unetTools.LossTerm{Name: "bce", Loss: bce, Weight: 0.3},
+		unetTools.LossTerm{Name: "dice", Loss: dice, Weight: 0.7},
+	)
+
+	want := 0.3*bce.Value(pred, target) + 0.7*dice.Value(pred, target)
+	if got := loss.Value(pred, target); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Expected composite loss %g, but got %g", want, got)
+	}
+	bceGrad, diceGrad := bce.Grad(pred, target), dice.Grad(pred, target)
+	for i, g := range loss.Grad(pred, target).Data {
+		if want := 0.3*bceGrad.Data[i] + 0.7*diceGrad.Data[i]; math.Abs(g-want) > 1e-12 {
+			t.Fatalf("Expected composite gradient %g at %d, but got %g", want, i, g)
+		}
+	}
+
+	components := loss.Components()
+	if components[0].Name != "bce" || math.Abs(components[0].Value-bce.Value(pred, target)) > 1e-12 {
+		t.Errorf("Expected the bce component to report its unweighted value, but got %+v", components[0])
+	}
+	if components[1].Weight != 0.7 {
+		t.Errorf("Expected the dice component to report weight 0.7, but got %g", components[1].Weight)
+	}
+}
+
+func TestCompositeLossScheduledWeights(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	loss := unetTools.NewCompositeLoss(
+		unetTools.LossTerm{Name: "mse", Loss: unetTools.MSE{}, Weight: 1},
+		// the Dice term is ramped up over the first 4 steps
+		unetTools.LossTerm{Name: "dice", Loss: unetTools.NewDice(1), Weight: 0.8, Schedule: unetTools.NewLinearWarmup(4, nil)},
+	)
+	net := unetTools.NewUnet(16, 1, 2, 2, "sigmoid", 3, 2, 2, 0.001, loss, unetTools.WithOptimizer(unetTools.NewSGD()))
+	input := randomTensor(rng, 1, 1, 16, 16)
+	target := randomMask(rng, 1, 1, 16, 16)
+
+	for step, want := range []float64{0.2, 0.4, 0.6, 0.8, 0.8} {
+		net.Backward(net.Loss(net.Forward(input), target))
+		if got := loss.Components()[1].Weight; math.Abs(got-want) > 1e-12 {
+			t.Errorf("step %d: expected the dice weight %g, but got %g", step, want, got)
+		}
+	}
+}
+
+// countingLoss is MSE that counts the calls to Grad
+type countingLoss struct {
+	unetTools.MSE
+	grads int
+}
+
+func (l *countingLoss) Grad(pred, target *unetTools.Tensor) *unetTools.Tensor {
+	l.grads++
+	return l.MSE.Grad(pred, target)
+}
+
+func TestUnetBackwardSeedsFromLossGrad(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	loss := &countingLoss{}
+	net := unetTools.NewUnet(16, 1, 2, 2, "sigmoid", 3, 2, 2, 0.001, loss)
+	input := randomTensor(rng, 2, 1, 16, 16)
+	target := randomTensor(rng, 2, 1, 16, 16)
+
+	output := net.Forward(input)
+	net.Backward(net.Loss(output, target))
+
+	if loss.grads != 2 {
+		t.Errorf("Expected Loss.Grad to be called once per sample, but it was called %d times", loss.grads)
+	}
+}
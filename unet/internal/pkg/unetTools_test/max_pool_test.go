@@ -0,0 +1,65 @@
+package unetTools_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestMaxPoolLayerBackwardRoutesToArgmax(t *testing.T) {
+	mpl := unetTools.NewMaxPoolLayer(2, 2)
+	input := unetTools.NewTensorFromData(1, 1, 2, 4, []float64{
+		1, 5, 2, 0,
+		3, 4, 7, 6,
+	})
+
+	_ = mpl.Forward(input)
+	gradInput := mpl.Backward(unetTools.NewTensorFromData(1, 1, 1, 2, []float64{10, 20}))
+
+	expected := []float64{
+		0, 10, 0, 0,
+		0, 0, 20, 0,
+	}
+	if gradInput.Shape != input.Shape {
+		t.Fatalf("Expected gradInput shape %v, but got %v", input.Shape, gradInput.Shape)
+	}
+	for i := range expected {
+		if gradInput.Data[i] != expected[i] {
+			t.Errorf("Expected gradInput %v, but got %v", expected, gradInput.Data)
+			break
+		}
+	}
+}
+
+func TestMaxPoolLayerBackwardOverlappingWindows(t *testing.T) {
+	// with stride 1 the centre element wins all four 2x2 windows
+	mpl := unetTools.NewMaxPoolLayer(2, 1)
+	input := unetTools.NewTensorFromData(1, 1, 3, 3, []float64{
+		0, 0, 0,
+		0, 9, 0,
+		0, 0, 0,
+	})
+
+	_ = mpl.Forward(input)
+	gradInput := mpl.Backward(unetTools.NewTensorFromData(1, 1, 2, 2, []float64{1, 2, 3, 4}))
+
+	if gradInput.At(0, 0, 1, 1) != 10 || gradInput.Sum() != 10 {
+		t.Errorf("Expected all gradient on the centre element, but got %v", gradInput.Data)
+	}
+}
+
+func TestMaxPoolLayerBackwardGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	mpl := unetTools.NewMaxPoolLayer(3, 2)
+	input := randomTensor(rng, 2, 2, 7, 8)
+
+	loss := func() float64 {
+		return sumOfSquares(mpl.Forward(input), nil)
+	}
+
+	output := mpl.Forward(input)
+	gradInput := mpl.Backward(sumOfSquaresGrad(output, nil))
+
+	checkGradient(t, "input", loss, input, gradInput)
+}
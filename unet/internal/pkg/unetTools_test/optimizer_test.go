@@ -0,0 +1,183 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestOptimizersMinimizeQuadratic(t *testing.T) {
+	optimizers := map[string]unetTools.Optimizer{
+		"sgd":      unetTools.NewSGD(),
+		"nesterov": unetTools.NewNesterov(0.9),
+		"rmsprop":  unetTools.NewRMSProp(0.9, 1e-8),
+		"adam":     unetTools.NewAdam(0.9, 0.999, 1e-8),
+		"adamw":    unetTools.NewAdamW(0.9, 0.999, 1e-8, 0.01),
+	}
+	for name, opt := range optimizers {
+		rng := rand.New(rand.NewSource(1))
+		param := randomTensor(rng, 2, 3, 2, 2)
+		before := sumOfSquares(param, nil)
+		for i := 0; i < 200; i++ {
+			// the gradient of sumOfSquares is the parameter itself
+			opt.Update([]*unetTools.Tensor{param}, []*unetTools.Tensor{sumOfSquaresGrad(param, nil)}, 0.01)
+		}
+		if after := sumOfSquares(param, nil); after > before/10 {
+			t.Errorf("%s: expected the loss to drop from %f below %f, but got %f", name, before, before/10, after)
+		}
+	}
+}
+
+func TestNesterovSteps(t *testing.T) {
+	param := unetTools.NewTensorFromData(1, 1, 1, 1, []float64{1})
+	grad := unetTools.NewTensorFromData(1, 1, 1, 1, []float64{2})
+	opt := unetTools.NewNesterov(0.5)
+
+	// v = 2, p = 1 - 0.1*(2 + 0.5*2) = 0.7
+	opt.Update([]*unetTools.Tensor{param}, []*unetTools.Tensor{grad}, 0.1)
+	// v = 0.5*2 + 2 = 3, p = 0.7 - 0.1*(2 + 0.5*3) = 0.35
+	opt.Update([]*unetTools.Tensor{param}, []*unetTools.Tensor{grad}, 0.1)
+
+	if math.Abs(param.Data[0]-0.35) > 1e-12 {
+		t.Errorf("Expected 0.35 after two steps, but got %v", param.Data[0])
+	}
+}
+
+func TestAdamWDecouplesWeightDecay(t *testing.T) {
+	param := unetTools.NewTensorFromData(1, 1, 1, 2, []float64{1, -2})
+	zero := unetTools.NewTensorLike(param)
+
+	// without gradients Adam does not move, AdamW still shrinks the parameters
+	unetTools.NewAdam(0.9, 0.999, 1e-8).Update([]*unetTools.Tensor{param}, []*unetTools.Tensor{zero}, 0.1)
+	if param.Data[0] != 1 || param.Data[1] != -2 {
+		t.Errorf("Expected Adam to leave the parameters unchanged, but got %v", param.Data)
+	}
+	unetTools.NewAdamW(0.9, 0.999, 1e-8, 0.5).Update([]*unetTools.Tensor{param}, []*unetTools.Tensor{zero}, 0.1)
+	if math.Abs(param.Data[0]-0.95) > 1e-12 || math.Abs(param.Data[1]+1.9) > 1e-12 {
+		t.Errorf("Expected AdamW to shrink the parameters to [0.95 -1.9], but got %v", param.Data)
+	}
+}
+
+func TestOptimizerMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Update to panic on mismatched parameters and gradients")
+		}
+	}()
+	param := unetTools.NewTensor(1, 1, 2, 2)
+	unetTools.NewSGD().Update([]*unetTools.Tensor{param}, nil, 0.1)
+}
+
+func TestUnetBackwardUsesOptimizer(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	net := unetTools.NewUnet(16, 1, 1, 2, "sigmoid", 3, 2, 2, 0.01, unetTools.MSE{},
+		unetTools.WithOptimizer(unetTools.NewSGD()))
+	input := randomTensor(rng, 1, 1, 16, 16)
+	var before []*unetTools.Tensor
+	for _, param := range net.Params() {
+		before = append(before, param.Clone())
+	}
+
+	net.Backward(net.Loss(net.Forward(input), input))
+
+	changed := false
+	for i, param := range net.Params() {
+		for j, v := range param.Data {
+			if v != before[i].Data[j] {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		t.Errorf("Expected Backward to change the parameters")
+	}
+	for _, grad := range net.Grads() {
+		for i, g := range grad.Data {
+			if g != 0 {
+				t.Fatalf("Expected Backward to reset the gradients, but gradient %d is %f", i, g)
+			}
+		}
+	}
+}
+
+// referenceAdam is a textbook Adam on a single parameter vector
+type referenceAdam struct {
+	beta1, beta2, epsilon float64
+	m, v                  []float64
+	t                     int
+}
+
+func (ref *referenceAdam) step(param, grad []float64, learningRate float64) {
+	if ref.m == nil {
+		ref.m = make([]float64, len(param))
+		ref.v = make([]float64, len(param))
+	}
+	ref.t++
+	for i, g := range grad {
+		ref.m[i] = ref.beta1*ref.m[i] + (1-ref.beta1)*g
+		ref.v[i] = ref.beta2*ref.v[i] + (1-ref.beta2)*g*g
+		mHat := ref.m[i] / (1 - math.Pow(ref.beta1, float64(ref.t)))
+		vHat := ref.v[i] / (1 - math.Pow(ref.beta2, float64(ref.t)))
+		param[i] -= learningRate * mHat / (math.Sqrt(vHat) + ref.epsilon)
+	}
+}
+
+func TestAdamMatchesReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// two filters' worth of weights and their biases, updated together
+	weights := randomTensor(rng, 2, 3, 3, 3)
+	biases := randomTensor(rng, 2, 1, 1, 1)
+	refWeights := append([]float64(nil), weights.Data...)
+	refBiases := append([]float64(nil), biases.Data...)
+
+	opt := unetTools.NewAdam(0.9, 0.999, 1e-8)
+	refW := &referenceAdam{beta1: 0.9, beta2: 0.999, epsilon: 1e-8}
+	refB := &referenceAdam{beta1: 0.9, beta2: 0.999, epsilon: 1e-8}
+	for step := 0; step < 20; step++ {
+		gradWeights := randomTensor(rng, 2, 3, 3, 3)
+		gradBiases := randomTensor(rng, 2, 1, 1, 1)
+		opt.Update([]*unetTools.Tensor{weights, biases}, []*unetTools.Tensor{gradWeights, gradBiases}, 0.01)
+		refW.step(refWeights, gradWeights.Data, 0.01)
+		refB.step(refBiases, gradBiases.Data, 0.01)
+	}
+
+	if opt.Steps() != 20 {
+		t.Errorf("Expected 20 optimizer steps, but got %d", opt.Steps())
+	}
+	for i, v := range refWeights {
+		if math.Abs(weights.Data[i]-v) > 1e-15 {
+			t.Fatalf("weight %d: expected %v, but got %v", i, v, weights.Data[i])
+		}
+	}
+	for i, v := range refBiases {
+		if math.Abs(biases.Data[i]-v) > 1e-15 {
+			t.Fatalf("bias %d: expected %v, but got %v", i, v, biases.Data[i])
+		}
+	}
+}
+
+func TestAdamStateIsPerParameter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a := randomTensor(rng, 1, 1, 3, 3)
+	b := randomTensor(rng, 1, 1, 3, 3)
+	alone := b.Clone()
+	gradA := randomTensor(rng, 1, 1, 3, 3)
+	gradA.Scale(100)
+	gradB := randomTensor(rng, 1, 1, 3, 3)
+
+	together := unetTools.NewAdam(0.9, 0.999, 1e-8)
+	separate := unetTools.NewAdam(0.9, 0.999, 1e-8)
+	for step := 0; step < 5; step++ {
+		together.Update([]*unetTools.Tensor{a, b}, []*unetTools.Tensor{gradA, gradB}, 0.01)
+		separate.Update([]*unetTools.Tensor{alone}, []*unetTools.Tensor{gradB}, 0.01)
+	}
+
+	// the large gradients of a must not leak into the moments of b
+	for i, v := range alone.Data {
+		if b.Data[i] != v {
+			t.Fatalf("element %d: expected %v, but got %v", i, v, b.Data[i])
+		}
+	}
+}
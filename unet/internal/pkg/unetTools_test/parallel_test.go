@@ -0,0 +1,74 @@
+package unetTools_test
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestSetNumWorkers(t *testing.T) {
+	defer unetTools.SetNumWorkers(0)
+
+	unetTools.SetNumWorkers(3)
+	if unetTools.NumWorkers() != 3 {
+		t.Errorf("expected 3 workers, but got %d", unetTools.NumWorkers())
+	}
+	unetTools.SetNumWorkers(0)
+	if unetTools.NumWorkers() < 1 {
+		t.Errorf("expected the default number of workers to be positive, but got %d", unetTools.NumWorkers())
+	}
+}
+
+// runLayer runs one forward and backward pass of layer and returns the
+// output, the input gradient and the parameter gradients
+func runLayer(layer unetTools.Layer, input, gradOutput *unetTools.Tensor) []*unetTools.Tensor {
+	for _, grad := range layer.Grads() {
+		grad.Zero()
+	}
+	results := []*unetTools.Tensor{layer.Forward(input).Clone()}
+	results = append(results, layer.Backward(gradOutput))
+	for _, grad := range layer.Grads() {
+		results = append(results, grad.Clone())
+	}
+	return results
+}
+
+func TestLayersDeterministicAcrossWorkers(t *testing.T) {
+	defer unetTools.SetNumWorkers(0)
+	rng := rand.New(rand.NewSource(1))
+
+	layers := []struct {
+		name  string
+		layer unetTools.Layer
+	}{
+		{"gemm conv", unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			Activation: "sigmoid", InputChannels: 3, KernelSize: 3, NumFilters: 5, Padding: unetTools.PaddingSame,
+		})},
+		{"naive conv", unetTools.NewConvLayerFromParams(unetTools.ConvParams{
+			Activation: "sigmoid", InputChannels: 3, KernelSize: 3, NumFilters: 5, Padding: unetTools.PaddingSame,
+			Backend: unetTools.ConvBackendNaive,
+		})},
+		{"conv trans", unetTools.NewConvTransLayer(3, 2, 2, 5, "sigmoid")},
+	}
+	input := randomTensor(rng, 2, 3, 8, 8)
+
+	for _, c := range layers {
+		name, layer := c.name, c.layer
+		unetTools.SetNumWorkers(1)
+		output := layer.Forward(input)
+		gradOutput := randomTensor(rng, output.Shape[0], output.Shape[1], output.Shape[2], output.Shape[3])
+		want := runLayer(layer, input, gradOutput)
+
+		for _, workers := range []int{2, 3, 8} {
+			unetTools.SetNumWorkers(workers)
+			got := runLayer(layer, input, gradOutput)
+			for i := range want {
+				if !slices.Equal(want[i].Data, got[i].Data) {
+					t.Errorf("%s with %d workers: result %d differs from a single worker", name, workers, i)
+				}
+			}
+		}
+	}
+}
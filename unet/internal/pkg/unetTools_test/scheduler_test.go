@@ -0,0 +1,77 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+// checkSchedule compares the learning rates of the first steps of a schedule against want
+func checkSchedule(t *testing.T, name string, s unetTools.Scheduler, base float64, want []float64) {
+	t.Helper()
+	for step, w := range want {
+		if got := s.LearningRate(step, base); math.Abs(got-w) > 1e-12 {
+			t.Errorf("%s: expected learning rate %v at step %d, but got %v", name, w, step, got)
+		}
+	}
+}
+
+func TestSchedulers(t *testing.T) {
+	checkSchedule(t, "step decay", unetTools.NewStepDecay(2, 0.5), 1,
+		[]float64{1, 1, 0.5, 0.5, 0.25})
+	checkSchedule(t, "exponential decay", unetTools.NewExponentialDecay(0.5), 1,
+		[]float64{1, 0.5, 0.25, 0.125})
+	// cycles of 2 and 4 steps, annealed from 1 to 0
+	checkSchedule(t, "cosine warm restarts", unetTools.NewCosineWarmRestarts(2, 2, 0), 1,
+		[]float64{1, 0.5, 1, (1 + math.Cos(math.Pi/4)) / 2, 0.5, (1 + math.Cos(3*math.Pi/4)) / 2, 1})
+	checkSchedule(t, "linear warmup", unetTools.NewLinearWarmup(4, unetTools.NewExponentialDecay(0.5)), 1,
+		[]float64{0.25, 0.5, 0.75, 1, 1, 0.5})
+	// rises from 0.1 to 1 over 2 steps, then anneals to 0.01 over 2 steps
+	checkSchedule(t, "one cycle", unetTools.NewOneCycle(5, 0.5, 10, 10), 1,
+		[]float64{0.1, 0.55, 1, 0.505, 0.01, 0.01})
+}
+
+func TestReduceOnPlateau(t *testing.T) {
+	s := unetTools.NewReduceOnPlateau(0.5, 1, 0, 0.2)
+	losses := []float64{1, 0.9, 0.9, 0.95, 0.8, 0.8, 0.8, 0.8, 0.8}
+	want := []float64{1, 1, 1, 0.5, 0.5, 0.5, 0.25, 0.25, 0.2}
+	for i, loss := range losses {
+		s.Observe(loss)
+		if got := s.LearningRate(i, 1); got != want[i] {
+			t.Errorf("after observing %v: expected learning rate %v, but got %v", losses[:i+1], want[i], got)
+		}
+	}
+}
+
+func TestUnetAppliesScheduler(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	net := unetTools.NewUnet(16, 1, 1, 2, "sigmoid", 3, 2, 2, 0.01, unetTools.MSE{},
+		unetTools.WithScheduler(unetTools.NewStepDecay(1, 0.5)))
+	input := randomTensor(rng, 1, 1, 16, 16)
+
+	for _, want := range []float64{0.01, 0.005, 0.0025} {
+		net.Backward(net.Loss(net.Forward(input), input))
+		if got := net.GetLearningRate(); math.Abs(got-want) > 1e-15 {
+			t.Errorf("expected learning rate %v, but got %v", want, got)
+		}
+	}
+}
+
+func TestUnetValidateFeedsPlateauScheduler(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	plateau := unetTools.NewReduceOnPlateau(0.1, 0, 0, 0)
+	net := unetTools.NewUnet(16, 1, 1, 2, "sigmoid", 3, 2, 2, 0.01, unetTools.MSE{},
+		unetTools.WithScheduler(plateau))
+	input := randomTensor(rng, 1, 1, 16, 16)
+
+	// the same loss twice is a plateau
+	net.Validate(input, input)
+	net.Validate(input, input)
+
+	net.Backward(net.Loss(net.Forward(input), input))
+	if got := net.GetLearningRate(); math.Abs(got-0.001) > 1e-15 {
+		t.Errorf("expected the learning rate to drop to 0.001, but got %v", got)
+	}
+}
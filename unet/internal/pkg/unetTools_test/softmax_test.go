@@ -0,0 +1,112 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestSoftmaxNormalizesChannels(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := randomTensor(rng, 2, 3, 4, 5)
+	input.Scale(10)
+
+	output := unetTools.NewSoftmaxLayer().Forward(input)
+
+	for s := 0; s < 2; s++ {
+		for i := 0; i < 20; i++ {
+			sum := 0.0
+			for c := 0; c < 3; c++ {
+				sum += output.Sample(s).Data[c*20+i]
+			}
+			if math.Abs(sum-1) > 1e-12 {
+				t.Fatalf("sample %d, pixel %d: expected the channels to sum to 1, but got %g", s, i, sum)
+			}
+		}
+	}
+}
+
+func TestSoftmaxGradient(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	layer := unetTools.NewSoftmaxLayer()
+	input := randomTensor(rng, 2, 3, 3, 3)
+	weights := randomTensor(rng, 2, 3, 3, 3)
+	// a loss that is linear in the output, so its gradient is weights
+	f := func() float64 {
+		output := layer.Forward(input).Clone()
+		output.MulElem(weights)
+		return output.Sum()
+	}
+
+	f()
+	checkGradient(t, "softmax", f, input, layer.Backward(weights))
+}
+
+func TestCategoricalCrossEntropyThroughSoftmax(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	logits := randomTensor(rng, 1, 4, 3, 3)
+	target := unetTools.OneHot(unetTools.NewTensorFromData(1, 1, 3, 3, []float64{0, 1, 2, 3, 0, 1, 2, 3, 0}), 4)
+	layer := unetTools.NewSoftmaxLayer()
+	loss := unetTools.CategoricalCrossEntropy{}
+
+	probabilities := layer.Forward(logits)
+	grad := layer.Backward(loss.Grad(probabilities, target))
+
+	// the gradient of the mean cross-entropy with respect to the logits is (p - t) / pixels
+	for i, g := range grad.Data {
+		if want := (probabilities.Data[i] - target.Data[i]) / 9; math.Abs(g-want) > 1e-9 {
+			t.Fatalf("Expected the gradient %g at %d, but got %g", want, i, g)
+		}
+	}
+}
+
+func TestArgmaxChannelsAndOneHot(t *testing.T) {
+	labels := unetTools.NewTensorFromData(2, 1, 1, 3, []float64{2, 0, 1, 1, 1, 0})
+
+	oneHot := unetTools.OneHot(labels, 3)
+	if oneHot.Shape != [4]int{2, 3, 1, 3} {
+		t.Fatalf("Expected one-hot shape [2 3 1 3], but got %v", oneHot.Shape)
+	}
+	if oneHot.At(0, 2, 0, 0) != 1 || oneHot.At(1, 0, 0, 2) != 1 || oneHot.Sum() != 6 {
+		t.Errorf("Expected one 1 per pixel in the channel of its label, but got %v", oneHot.Data)
+	}
+	for i, label := range unetTools.ArgmaxChannels(oneHot).Data {
+		if label != labels.Data[i] {
+			t.Fatalf("Expected the argmax of the one-hot encoding to give back label %g at %d, but got %g", labels.Data[i], i, label)
+		}
+	}
+}
+
+func TestUnetMultiClassOutput(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	net := unetTools.NewUnet(16, 1, 2, 2, "relu", 3, 2, 2, 0.001, unetTools.CategoricalCrossEntropy{},
+		unetTools.WithNumClasses(3), unetTools.WithSeed(1))
+	input := randomTensor(rng, 2, 1, 16, 16)
+
+	probabilities, labels := net.Predict(input)
+
+	if probabilities.Shape != [4]int{2, 3, 16, 16} {
+		t.Fatalf("Expected probability maps of shape [2 3 16 16], but got %v", probabilities.Shape)
+	}
+	if labels.Shape != [4]int{2, 1, 16, 16} {
+		t.Fatalf("Expected label maps of shape [2 1 16 16], but got %v", labels.Shape)
+	}
+	for i := 0; i < 256; i++ {
+		sum := 0.0
+		for c := 0; c < 3; c++ {
+			sum += probabilities.At(1, c, i/16, i%16)
+		}
+		if math.Abs(sum-1) > 1e-12 {
+			t.Fatalf("pixel %d: expected the class probabilities to sum to 1, but got %g", i, sum)
+		}
+	}
+
+	// one training step against one-hot targets runs through the softmax
+	target := unetTools.OneHot(labels, 3)
+	net.Backward(net.Loss(probabilities, target))
+	if net.GetGradNorm() == 0 {
+		t.Errorf("Expected the categorical cross-entropy to reach the parameters")
+	}
+}
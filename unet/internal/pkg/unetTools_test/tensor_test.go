@@ -0,0 +1,102 @@
+package unetTools_test
+
+import (
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+func TestNewTensor(t *testing.T) {
+	tensor := unetTools.NewTensor(2, 3, 4, 5)
+
+	n, c, h, w := tensor.Dims()
+	if n != 2 || c != 3 || h != 4 || w != 5 {
+		t.Errorf("Expected shape 2x3x4x5, but got %dx%dx%dx%d", n, c, h, w)
+	}
+	if tensor.Len() != 120 {
+		t.Errorf("Expected 120 elements, but got %d", tensor.Len())
+	}
+	if tensor.Strides != [4]int{60, 20, 5, 1} {
+		t.Errorf("Expected strides [60 20 5 1], but got %v", tensor.Strides)
+	}
+}
+
+func TestTensorChannelSharesStorage(t *testing.T) {
+	tensor := unetTools.NewTensor(2, 3, 4, 5)
+
+	tensor.Channel(1, 2).Set(3, 4, 7)
+
+	if tensor.At(1, 2, 3, 4) != 7 {
+		t.Errorf("Expected write through Channel to be visible in tensor, but got %f", tensor.At(1, 2, 3, 4))
+	}
+}
+
+func TestTensorFromDense(t *testing.T) {
+	a := mat64.NewDense(2, 2, []float64{1, 2, 3, 4})
+	b := mat64.NewDense(2, 2, []float64{5, 6, 7, 8})
+
+	tensor := unetTools.TensorFromDense(a, b)
+
+	if tensor.Shape != [4]int{1, 2, 2, 2} {
+		t.Errorf("Expected shape [1 2 2 2], but got %v", tensor.Shape)
+	}
+	if tensor.At(0, 1, 1, 0) != 7 {
+		t.Errorf("Expected element (0, 1, 1, 0) to be 7, but got %f", tensor.At(0, 1, 1, 0))
+	}
+}
+
+func TestConcatAndSplitChannels(t *testing.T) {
+	a := unetTools.NewTensor(2, 1, 2, 2)
+	b := unetTools.NewTensor(2, 3, 2, 2)
+	for i := range a.Data {
+		a.Data[i] = float64(i)
+	}
+	for i := range b.Data {
+		b.Data[i] = float64(100 + i)
+	}
+
+	concat := unetTools.ConcatChannels(a, b)
+	if concat.Shape != [4]int{2, 4, 2, 2} {
+		t.Fatalf("Expected shape [2 4 2 2], but got %v", concat.Shape)
+	}
+	if concat.At(1, 0, 1, 1) != a.At(1, 0, 1, 1) || concat.At(1, 3, 0, 1) != b.At(1, 2, 0, 1) {
+		t.Errorf("Concatenated values do not match their sources")
+	}
+
+	left, right := unetTools.SplitChannels(concat, 1)
+	for i := range a.Data {
+		if left.Data[i] != a.Data[i] {
+			t.Fatalf("Expected split to recover first tensor, mismatch at %d", i)
+		}
+	}
+	for i := range b.Data {
+		if right.Data[i] != b.Data[i] {
+			t.Fatalf("Expected split to recover second tensor, mismatch at %d", i)
+		}
+	}
+}
+
+func TestTensorShapeMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Add on mismatched shapes to panic")
+		}
+	}()
+	unetTools.NewTensor(1, 1, 2, 2).Add(unetTools.NewTensor(1, 1, 2, 3))
+}
+
+func TestStackSamples(t *testing.T) {
+	a := unetTools.NewTensorFromData(1, 2, 1, 1, []float64{1, 2})
+	b := unetTools.NewTensorFromData(2, 2, 1, 1, []float64{3, 4, 5, 6})
+
+	batch := unetTools.StackSamples(a, b)
+
+	if batch.Shape != [4]int{3, 2, 1, 1} {
+		t.Fatalf("Expected shape [3 2 1 1], but got %v", batch.Shape)
+	}
+	if batch.At(1, 1, 0, 0) != 4 || batch.At(2, 0, 0, 0) != 5 {
+		t.Errorf("Expected the samples in order, but got %v", batch.Data)
+	}
+}
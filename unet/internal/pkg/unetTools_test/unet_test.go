@@ -0,0 +1,41 @@
+package unetTools_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"unet/unet/internal/pkg/unetTools"
+)
+
+func TestUnetForwardKeepsInputSize(t *testing.T) {
+	net := unetTools.NewUnet(16, 1, 2, 2, "relu", 3, 2, 2, 0.001, unetTools.MSE{})
+
+	output := net.Forward(unetTools.NewTensor(1, 1, 16, 16))
+
+	if output.Shape != [4]int{1, 1, 16, 16} {
+		t.Errorf("Expected output shape [1 1 16 16], but got %v", output.Shape)
+	}
+}
+
+func TestUnetBatchMatchesSingleSamples(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	net := unetTools.NewUnet(16, 1, 2, 2, "sigmoid", 3, 2, 2, 0.001, unetTools.MSE{})
+	a := randomTensor(rng, 1, 1, 16, 16)
+	b := randomTensor(rng, 1, 1, 16, 16)
+
+	batch := net.Forward(unetTools.StackSamples(a, b))
+	outputA := net.Forward(a)
+	outputB := net.Forward(b)
+
+	if batch.Shape != [4]int{2, 1, 16, 16} {
+		t.Fatalf("Expected output shape [2 1 16 16], but got %v", batch.Shape)
+	}
+	for i, single := range []*unetTools.Tensor{outputA, outputB} {
+		for j, v := range single.Data {
+			if math.Abs(batch.Sample(i).Data[j]-v) > 1e-12 {
+				t.Fatalf("sample %d: batched output differs from its single-sample output at %d", i, j)
+			}
+		}
+	}
+}
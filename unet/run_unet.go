@@ -9,16 +9,16 @@ func main() {
 	// builds a simple U-Net model
 
 	my_net := unetTools.NewUnet(
-		572,                      // input size
-		1,                        // input channels
-		2,                        // number of encoder-decoder pairs
-		8,                        // maximum number of filters in conv layers
-		"sigmoid",                // activation function
-		3,                        // size of convolutional kernel
-		2,                        // size of pooling kernel
-		2,                        // stride of pooling kernel
-		0.001,                    // learning rate
-		unetTools.MeanSquaredErr, // loss function
+		572,             // input size
+		1,               // input channels
+		2,               // number of encoder-decoder pairs
+		8,               // maximum number of filters in conv layers
+		"sigmoid",       // activation function
+		3,               // size of convolutional kernel
+		2,               // size of pooling kernel
+		2,               // stride of pooling kernel
+		0.001,           // learning rate
+		unetTools.MSE{}, // loss function
 	)
 	my_net.Summary()
 
@@ -28,7 +28,7 @@ func main() {
 	// keep running steps until max step limit is reached
 	num_steps := 0
 	for num_steps < 10 {
-		my_net.Step(input, input, 0.001)
+		my_net.Step(unetTools.TensorFromDense(input), unetTools.TensorFromDense(input), 0.001)
 		num_steps++
 	}
 	fmt.Print(my_net.GetLoss())